@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"strings"
+
+	"github.com/bishopfox/sliver/protobuf/sliverpb"
+)
+
+const (
+	defaultSkipIfaces = "lo^docker*^veth*^br-*^virbr*^tun*"
+	defaultSkipCIDRs  = "127.0.0.0/8^172.17.0.1^::1"
+)
+
+// ipv6Modes are the values of --ipv6: which IPv6 addresses are used at all.
+var ipv6Modes = map[string][]string{
+	"off":    {},
+	"global": {"global", "ula"},
+	"all":    {"global", "ula", "link-local"},
+}
+
+// addrFilter decides which interface addresses of an implant are worth
+// renaming it after or reporting. Addresses on skipped interfaces or in
+// skipped CIDRs, and IPv6 addresses of classes not enabled by the IPv6 mode,
+// are dropped unless they are in the include list.
+type addrFilter struct {
+	skipIfaces []string // interface name globs
+	skipCIDRs  []netip.Prefix
+	include    []netip.Prefix
+	ipv6       []string // IPv6 address classes to keep
+}
+
+func parseAddrFilter(skipIfaces string, skipCIDRs string, include string, ipv6 string) (addrFilter, error) {
+	f := addrFilter{}
+	classes, ok := ipv6Modes[ipv6]
+	if !ok {
+		return f, fmt.Errorf("unknown IPv6 mode %q, expected off, global or all", ipv6)
+	}
+	f.ipv6 = classes
+	for _, pattern := range splitList(skipIfaces) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return f, err
+		}
+		f.skipIfaces = append(f.skipIfaces, pattern)
+	}
+	var err error
+	if f.skipCIDRs, err = parsePrefixes(skipCIDRs); err != nil {
+		return f, err
+	}
+	if f.include, err = parsePrefixes(include); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// splitList splits a ^ separated flag value, ignoring empty entries.
+func splitList(value string) []string {
+	list := []string{}
+	for _, entry := range strings.Split(value, "^") {
+		if entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, cidr := range splitList(value) {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func containedIn(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Keep reports whether addr, found on the interface named iface, passes the
+// filter.
+func (f addrFilter) Keep(iface string, addr netip.Addr) bool {
+	if containedIn(f.include, addr) {
+		return true
+	}
+	if addr.Is6() && !isinarray(f.ipv6, addrClass(addr)) {
+		return false
+	}
+	for _, pattern := range f.skipIfaces {
+		if ok, _ := path.Match(pattern, iface); ok {
+			return false
+		}
+	}
+	return !containedIn(f.skipCIDRs, addr)
+}
+
+// addrClass classifies addr as loopback, link-local, multicast, unspecified,
+// ula (IPv6 unique local), private (RFC 1918) or global.
+func addrClass(addr netip.Addr) string {
+	switch {
+	case addr.IsLoopback():
+		return "loopback"
+	case addr.IsLinkLocalUnicast():
+		return "link-local"
+	case addr.IsMulticast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast():
+		return "multicast"
+	case addr.IsUnspecified():
+		return "unspecified"
+	case addr.IsPrivate() && addr.Is6():
+		return "ula"
+	case addr.IsPrivate():
+		return "private"
+	}
+	return "global"
+}
+
+// ifconfigIPs returns every address in an ifconfig reply, without its prefix
+// length and before any filtering.
+func ifconfigIPs(ifconfig *sliverpb.Ifconfig) []string {
+	ips := []string{}
+	for _, iface := range ifconfig.NetInterfaces {
+		for _, ipaddr := range iface.IPAddresses {
+			if addr, err := netip.ParseAddr(strings.Split(ipaddr, "/")[0]); err == nil {
+				ips = append(ips, addr.Unmap().String())
+			}
+		}
+	}
+	return ips
+}
+
+// Addrs returns the interface addresses in an ifconfig reply that pass the
+// filter, without their prefix length.
+func (f addrFilter) Addrs(ifconfig *sliverpb.Ifconfig) []ifaceAddr {
+	addrs := []ifaceAddr{}
+	for _, iface := range ifconfig.NetInterfaces {
+		for _, ipaddr := range iface.IPAddresses {
+			addr, err := netip.ParseAddr(strings.Split(ipaddr, "/")[0])
+			if err != nil {
+				continue
+			}
+			addr = addr.Unmap()
+			if f.Keep(iface.Name, addr) {
+				addrs = append(addrs, ifaceAddr{iface: iface.Name, ip: addr.String()})
+			}
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ifaceAddr is an address gathered from one of an implant's interfaces.
+type ifaceAddr struct {
+	iface string
+	ip    string
+}
+
+// addrPolicy picks the one address a host is renamed after and reported as.
+// In order it prefers addresses in the preferred CIDRs (in their priority
+// order), then the address the implant connects from, then the first address
+// found, IPv4 before IPv6. Link-local addresses are only used if the host
+// has nothing else.
+// Addresses of virtual interfaces have already been dropped by the
+// addrFilter.
+type addrPolicy struct {
+	prefer []netip.Prefix
+
+	mu         sync.Mutex
+	multiHomed map[string]string // implant description -> choice
+}
+
+func parseAddrPolicy(prefer string) (*addrPolicy, error) {
+	p := &addrPolicy{multiHomed: map[string]string{}}
+	var err error
+	if p.prefer, err = parsePrefixes(prefer); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Primary picks t's primary address out of its interface addresses. It
+// reports false if t has none.
+func (p *addrPolicy) Primary(t Target, addrs []ifaceAddr) (string, bool) {
+	if len(addrs) == 0 {
+		return "", false
+	}
+	candidates := []ifaceAddr{}
+	for _, a := range addrs {
+		if !isLinkLocal(a) {
+			candidates = append(candidates, a)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = addrs
+	}
+
+	chosen, why := candidates[0], "first address"
+	for _, a := range candidates {
+		if addr, err := netip.ParseAddr(a.ip); err == nil && addr.Is4() {
+			chosen = a
+			break
+		}
+	}
+	if remote, ok := remoteAddr(t); ok {
+		for _, a := range candidates {
+			if addr, err := netip.ParseAddr(a.ip); err == nil && addr.Unmap() == remote {
+				chosen, why = a, "remote address"
+				break
+			}
+		}
+	}
+	for _, prefix := range p.prefer {
+		if a, ok := firstIn(candidates, prefix); ok {
+			chosen, why = a, "preferred "+prefix.String()
+			break
+		}
+	}
+
+	if len(addrs) > 1 {
+		all := make([]string, len(addrs))
+		for i, a := range addrs {
+			all[i] = a.iface + "=" + a.ip
+		}
+		p.mu.Lock()
+		p.multiHomed[describeTarget(t)] = fmt.Sprintf("%s (%s) from %s", chosen.ip, why, strings.Join(all, " "))
+		p.mu.Unlock()
+	}
+	return chosen.ip, true
+}
+
+func firstIn(addrs []ifaceAddr, prefix netip.Prefix) (ifaceAddr, bool) {
+	for _, a := range addrs {
+		if addr, err := netip.ParseAddr(a.ip); err == nil && prefix.Contains(addr.Unmap()) {
+			return a, true
+		}
+	}
+	return ifaceAddr{}, false
+}
+
+func isLinkLocal(a ifaceAddr) bool {
+	addr, err := netip.ParseAddr(a.ip)
+	return err == nil && addr.IsLinkLocalUnicast()
+}
+
+// Report prints every multi-homed host seen and the address chosen for it.
+func (p *addrPolicy) Report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.multiHomed) == 0 {
+		return
+	}
+	hosts := make([]string, 0, len(p.multiHomed))
+	for host := range p.multiHomed {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	log.Printf("[*] %d multi-homed hosts:\n", len(hosts))
+	for _, host := range hosts {
+		log.Printf("[*]   %s: %s\n", host, p.multiHomed[host])
+	}
+}
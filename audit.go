@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bishopfox/sliver/protobuf/sliverpb"
+)
+
+// audit records every action taken against an implant. It is nil when
+// auditing is off.
+var audit *auditLog
+
+// auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time        time.Time `json:"time"`
+	Operator    string    `json:"operator"`
+	RunID       string    `json:"run_id"`
+	Server      string    `json:"server,omitempty"`
+	Action      string    `json:"action"` // run, execute, ifconfig, rename, pwnboard or webhook
+	ImplantID   string    `json:"implant_id,omitempty"`
+	ImplantName string    `json:"implant_name,omitempty"`
+	Hostname    string    `json:"hostname,omitempty"`
+	Kind        string    `json:"kind,omitempty"`
+	Command     string    `json:"command,omitempty"`
+	Args        []string  `json:"args,omitempty"`
+	Name        string    `json:"name,omitempty"` // new implant name
+	URL         string    `json:"url,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	TaskID      string    `json:"task_id,omitempty"`
+	Outcome     string    `json:"outcome"` // issued, ok or error
+	Error       string    `json:"error,omitempty"`
+}
+
+// auditLog appends entries to a JSONL file. Every entry is written as soon as
+// it is recorded so the log survives the run being interrupted.
+type auditLog struct {
+	mu       sync.Mutex
+	f        *os.File
+	operator string
+	runID    string
+}
+
+func openAuditLog(path string, operator string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f, operator: operator, runID: hex.EncodeToString(id)}, nil
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+func (a *auditLog) record(e auditEntry) {
+	if a == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.Operator = a.operator
+	e.RunID = a.runID
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[!] Failed to write audit log: %s\n", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.f.Write(append(data, '\n')); err != nil {
+		log.Printf("[!] Failed to write audit log: %s\n", err)
+	}
+}
+
+// Run records the start of a run and the arguments it was started with.
+func (a *auditLog) Run(args []string) {
+	a.record(auditEntry{Action: "run", Args: args, Outcome: "ok"})
+}
+
+// auditArgs is args as they can be kept in the audit log, with the value of
+// every flag in redact replaced by what redact makes of it. Flags are given as
+// --name=value or as --name followed by the value.
+func auditArgs(args []string, redact map[string]func(string) string) []string {
+	logged := make([]string, 0, len(args))
+	var next func(string) string // redacts the value of the previous flag
+	for _, arg := range args {
+		if next != nil {
+			logged = append(logged, next(arg))
+			next = nil
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		f, ok := redact[name]
+		switch {
+		case !strings.HasPrefix(arg, "-") || !ok:
+			logged = append(logged, arg)
+		case hasValue:
+			logged = append(logged, strings.TrimSuffix(arg, value)+f(value))
+		default:
+			logged = append(logged, arg)
+			next = f
+		}
+	}
+	return logged
+}
+
+// redactSecret stands in for a secret flag value in the audit log.
+func redactSecret(string) string {
+	return "[redacted]"
+}
+
+// redactHeader keeps the name of a "Name: value" header, as its value is
+// usually a credential.
+func redactHeader(header string) string {
+	name, _, _ := strings.Cut(header, ":")
+	return name + ": " + redactSecret(header)
+}
+
+// Action records an action against t. A nil err with a task ID means a
+// beacon task was issued and its outcome is recorded once it completes.
+func (a *auditLog) Action(t Target, e auditEntry, err error) {
+	e.Server = t.Server()
+	e.ImplantID = t.ID()
+	e.ImplantName = t.Name()
+	e.Hostname = t.Hostname()
+	e.Kind = t.Kind()
+	switch {
+	case err != nil:
+		e.Outcome = "error"
+		e.Error = err.Error()
+	case e.Outcome == "":
+		e.Outcome = "ok"
+	}
+	a.record(e)
+}
+
+// Outcome records how a request issued by runOn turned out.
+func (a *auditLog) Outcome(o outcome) {
+	e := auditEntry{TaskID: o.taskID}
+	switch o.reply.(type) {
+	case *sliverpb.Execute:
+		e.Action = "execute"
+	case *sliverpb.Ifconfig:
+		e.Action = "ifconfig"
+	}
+	a.Action(o.target, e, o.err)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	consts "github.com/bishopfox/sliver/client/constants"
+	"github.com/bishopfox/sliver/protobuf/clientpb"
+	"github.com/bishopfox/sliver/protobuf/commonpb"
+	"github.com/bishopfox/sliver/protobuf/rpcpb"
+	"google.golang.org/protobuf/proto"
+)
+
+// pollInterval is how often outstanding beacon tasks are checked while the
+// event stream is down.
+const pollInterval = 10 * time.Second
+
+// taskAwaiter resolves beacon tasks as soon as the server publishes their
+// results on the event stream. If the stream drops it polls the tasks still
+// being waited on until it can subscribe again.
+type taskAwaiter struct {
+	rpc     rpcpb.SliverRPCClient
+	start   sync.Once
+	mu      sync.Mutex
+	waiting map[string]chan struct{}
+}
+
+var (
+	awaitersMu sync.Mutex
+	awaiters   = map[rpcpb.SliverRPCClient]*taskAwaiter{}
+)
+
+// awaiterFor returns the task awaiter for the server behind rpc.
+func awaiterFor(rpc rpcpb.SliverRPCClient) *taskAwaiter {
+	awaitersMu.Lock()
+	defer awaitersMu.Unlock()
+	a, ok := awaiters[rpc]
+	if !ok {
+		a = &taskAwaiter{rpc: rpc, waiting: map[string]chan struct{}{}}
+		awaiters[rpc] = a
+	}
+	return a
+}
+
+// Await blocks until the beacon task is completed or canceled and returns it
+// with its content, or until ctx is done.
+func (a *taskAwaiter) Await(ctx context.Context, taskID string) (*clientpb.BeaconTask, error) {
+	a.start.Do(func() { go a.run() })
+	resolved := a.watch(taskID)
+	defer a.forget(taskID)
+
+	// The result may have landed before we started watching for it.
+	task, err := a.rpc.GetBeaconTaskContent(ctx, &clientpb.BeaconTask{ID: taskID})
+	if err == nil && isFinished(task) {
+		return task, nil
+	}
+	select {
+	case <-resolved:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return a.rpc.GetBeaconTaskContent(ctx, &clientpb.BeaconTask{ID: taskID})
+}
+
+func (a *taskAwaiter) watch(taskID string) chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	resolved := make(chan struct{})
+	a.waiting[taskID] = resolved
+	return resolved
+}
+
+func (a *taskAwaiter) forget(taskID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.waiting, taskID)
+}
+
+func (a *taskAwaiter) resolve(taskID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if resolved, ok := a.waiting[taskID]; ok {
+		close(resolved)
+		delete(a.waiting, taskID)
+	}
+}
+
+func (a *taskAwaiter) run() {
+	for {
+		err := a.listen()
+		log.Printf("[!] Lost event stream (%s), polling beacon tasks", err)
+		a.poll()
+		time.Sleep(pollInterval)
+	}
+}
+
+// listen resolves tasks from the event stream until the stream fails.
+func (a *taskAwaiter) listen() error {
+	events, err := a.rpc.Events(context.Background(), &commonpb.Empty{})
+	if err != nil {
+		return err
+	}
+	// Catch anything that finished while we were not subscribed.
+	a.poll()
+	for {
+		event, err := events.Recv()
+		if err != nil {
+			return err
+		}
+		if event.EventType != consts.BeaconTaskResultEvent {
+			continue
+		}
+		task := &clientpb.BeaconTask{}
+		if err := proto.Unmarshal(event.Data, task); err != nil {
+			log.Printf("Failed to decode task result event: %s\n", err)
+			continue
+		}
+		a.resolve(task.ID)
+	}
+}
+
+// poll checks every task still being waited on and resolves finished ones.
+func (a *taskAwaiter) poll() {
+	a.mu.Lock()
+	taskIDs := make([]string, 0, len(a.waiting))
+	for taskID := range a.waiting {
+		taskIDs = append(taskIDs, taskID)
+	}
+	a.mu.Unlock()
+
+	for _, taskID := range taskIDs {
+		task, err := a.rpc.GetBeaconTaskContent(context.Background(), &clientpb.BeaconTask{ID: taskID})
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		if isFinished(task) {
+			a.resolve(taskID)
+		}
+	}
+}
+
+func isFinished(task *clientpb.BeaconTask) bool {
+	return task.State == "completed" || task.State == "canceled"
+}
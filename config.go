@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bishopfox/sliver/client/assets"
+)
+
+// configEnv selects a config the same way --config does when the flag is
+// not given.
+const configEnv = "SLIVERER_CONFIG"
+
+// clientConfig is a client config and where it was loaded from.
+type clientConfig struct {
+	path   string // empty for inline configs
+	config *assets.ClientConfig
+}
+
+func (c clientConfig) server() string {
+	return c.config.LHost + ":" + strconv.Itoa(c.config.LPort)
+}
+
+// name identifies the server the config connects to as operator@host:port.
+func (c clientConfig) name() string {
+	return c.config.Operator + "@" + c.server()
+}
+
+func (c clientConfig) String() string {
+	source := c.path
+	if source == "" {
+		source = "inline config"
+	}
+	return fmt.Sprintf("%s (%s)", c.name(), source)
+}
+
+// configDir is where the sliver client keeps its configs, honouring the
+// client's SLIVER_CLIENT_ROOT_DIR override.
+func configDir() string {
+	root := os.Getenv("SLIVER_CLIENT_ROOT_DIR")
+	if root == "" {
+		home, _ := os.UserHomeDir()
+		root = filepath.Join(home, ".sliver-client")
+	}
+	return filepath.Join(root, "configs")
+}
+
+// listConfigs loads every config in dir, skipping files that are not configs.
+func listConfigs(dir string) ([]clientConfig, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	configs := []clientConfig{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		config, err := assets.ReadConfig(path)
+		if err != nil {
+			continue
+		}
+		configs = append(configs, clientConfig{path: path, config: config})
+	}
+	return configs, nil
+}
+
+// ListConfigs prints the configs found in the client config directory.
+func ListConfigs() {
+	dir := configDir()
+	configs, err := listConfigs(dir)
+	if err != nil {
+		fmt.Println("Error listing configs:", err)
+		os.Exit(1)
+	}
+	if len(configs) == 0 {
+		fmt.Println("No configs in " + dir)
+	}
+	for _, c := range configs {
+		fmt.Println(c)
+	}
+}
+
+// resolveConfig finds the config spec refers to. spec is a path to a config
+// file, an inline base64 config (optionally prefixed with "base64:"), or an
+// operator, server or file name matching exactly one config in configDir. An
+// empty spec falls back to $SLIVERER_CONFIG and then to the only config in
+// configDir.
+func resolveConfig(spec string) (clientConfig, error) {
+	if spec == "" {
+		spec = os.Getenv(configEnv)
+	}
+	if spec != "" {
+		if _, err := os.Stat(spec); err == nil {
+			config, err := assets.ReadConfig(spec)
+			return clientConfig{path: spec, config: config}, err
+		}
+		if config, ok := decodeInlineConfig(spec); ok {
+			return clientConfig{config: config}, nil
+		}
+		if strings.HasPrefix(spec, "base64:") {
+			// Not echoed back, it may hold most of a private key.
+			return clientConfig{}, errors.New("the base64: config is not a valid client config")
+		}
+	}
+
+	dir := configDir()
+	configs, err := listConfigs(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return clientConfig{}, err
+	}
+	matches := []clientConfig{}
+	for _, c := range configs {
+		if spec == "" || configMatches(c, spec) {
+			matches = append(matches, c)
+		}
+	}
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(configs) == 0:
+		return clientConfig{}, fmt.Errorf("no configs in %s, use --config or $%s to give one", dir, configEnv)
+	case len(matches) == 0:
+		return clientConfig{}, fmt.Errorf("no config in %s matches %q, found:\n%s", dir, spec, configList(configs))
+	}
+	return clientConfig{}, fmt.Errorf("several configs in %s match %q, pick one with --config or $%s:\n%s", dir, spec, configEnv, configList(matches))
+}
+
+// configMatches reports whether name is c's operator, server, operator@server
+// or file name.
+func configMatches(c clientConfig, name string) bool {
+	base := filepath.Base(c.path)
+	return name == c.config.Operator ||
+		name == c.config.LHost ||
+		name == c.server() ||
+		name == c.config.Operator+"@"+c.config.LHost ||
+		name == c.config.Operator+"@"+c.server() ||
+		name == base ||
+		name == strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+func decodeInlineConfig(spec string) (*assets.ClientConfig, bool) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(spec, "base64:"))
+	if err != nil {
+		return nil, false
+	}
+	config := &assets.ClientConfig{}
+	if err := json.Unmarshal(data, config); err != nil || config.LHost == "" {
+		return nil, false
+	}
+	return config, true
+}
+
+// configName is the operator@host:port spec refers to, which is what the
+// audit log keeps instead of spec: an inline config holds the operator's
+// private key.
+func configName(spec string) string {
+	c, err := resolveConfig(spec)
+	if err != nil {
+		return "[unresolved]"
+	}
+	return c.name()
+}
+
+func configList(configs []clientConfig) string {
+	lines := make([]string, len(configs))
+	for i, c := range configs {
+		lines[i] = "  " + c.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// resolveConfigs finds the config of every server a run should connect to:
+// each config in dir if one is given, plus the config every spec refers to.
+// With neither it resolves the single default config. A server given more
+// than once, by the same file or by configs for the same operator@host:port,
+// is only connected to once so its implants are not acted on twice.
+func resolveConfigs(specs []string, dir string) ([]clientConfig, error) {
+	configs := []clientConfig{}
+	seen := map[string]bool{}
+	add := func(c clientConfig) {
+		if !seen[c.name()] {
+			seen[c.name()] = true
+			configs = append(configs, c)
+		}
+	}
+	if dir != "" {
+		found, err := listConfigs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("no configs in %s", dir)
+		}
+		for _, c := range found {
+			add(c)
+		}
+	}
+	if len(specs) == 0 && dir == "" {
+		specs = []string{""}
+	}
+	for _, spec := range specs {
+		c, err := resolveConfig(spec)
+		if err != nil {
+			return nil, err
+		}
+		add(c)
+	}
+	return configs, nil
+}
+
+// configFlag collects every --config given on the command line.
+type configFlag []string
+
+func (c *configFlag) String() string { return strings.Join(*c, ",") }
+
+func (c *configFlag) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultDangerous are the commands that always need confirmation.
+var defaultDangerous = []string{
+	`rm\s+-\w*[rf]`,
+	`\bshutdown\b`, `\breboot\b`, `\bhalt\b`, `\bpoweroff\b`, `\binit\s+[06]\b`,
+	`\bmkfs`, `\bdd\s+.*of=`, `\bformat\s+\w:`, `\bdel\s+/[sq]`, `\brd\s+/s`,
+	`\buserdel\b`, `\bpasswd\b`, `\bkill\s+-9\s+-1\b`, `\bcrontab\s+-r\b`,
+	`\biptables\s+-F\b`, `Stop-Computer`, `Restart-Computer`, `Remove-Item.*-Recurse`,
+}
+
+func parseDangerous(patterns string) ([]*regexp.Regexp, error) {
+	list := defaultDangerous
+	if patterns != "" {
+		list = strings.Split(patterns, "^")
+	}
+	dangerous := []*regexp.Regexp{}
+	for _, pattern := range list {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, err
+		}
+		dangerous = append(dangerous, re)
+	}
+	return dangerous, nil
+}
+
+// confirmCommand decides whether command may run on targets. Runs that would
+// task more than opts.confirmOver implants, or whose command line looks
+// dangerous, print their blast radius and need the operator to type the
+// number of implants back, unless opts.yes is set.
+func confirmCommand(opts runOptions, targets []Target, command string, args []string) bool {
+	live := []Target{}
+	for _, t := range targets {
+		if !t.IsDead() && opts.scope.Allows(t) {
+			live = append(live, t)
+		}
+	}
+	line := strings.Join(append([]string{command}, args...), " ")
+	reasons := []string{}
+	if len(live) > opts.confirmOver {
+		reasons = append(reasons, fmt.Sprintf("it would task %d implants (more than %d)", len(live), opts.confirmOver))
+	}
+	for _, re := range opts.dangerous {
+		if re.MatchString(line) {
+			reasons = append(reasons, "the command matches "+re.String())
+			break
+		}
+	}
+	if len(reasons) == 0 || opts.yes {
+		return true
+	}
+
+	fmt.Fprintf(os.Stderr, "[!] %s needs confirmation because %s\n", quoteArgs(append([]string{command}, args...)), strings.Join(reasons, " and "))
+	printBreakdown("os", live, func(t Target) string { return t.OS() })
+	printBreakdown("subnet", live, subnet)
+	fmt.Fprintf(os.Stderr, "Type %d to run it on %d implants, or anything else to stop (--yes skips this): ", len(live), len(live))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == strconv.Itoa(len(live))
+}
+
+func printBreakdown(label string, targets []Target, key func(Target) string) {
+	counts := map[string]int{}
+	for _, t := range targets {
+		counts[key(t)]++
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(os.Stderr, "  by %s:\n", label)
+	for _, k := range keys {
+		fmt.Fprintf(os.Stderr, "    %-20s %d\n", k, counts[k])
+	}
+}
+
+// subnet is the /24 (or /64 for IPv6) t connects from.
+func subnet(t Target) string {
+	addr, ok := remoteAddr(t)
+	if !ok {
+		return "unknown"
+	}
+	bits := 24
+	if addr.Is6() {
+		bits = 64
+	}
+	return netip.PrefixFrom(addr, bits).Masked().String()
+}
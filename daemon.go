@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bishopfox/sliver/protobuf/sliverpb"
+)
+
+// implantState is what the daemon remembers about an implant it has handled.
+type implantState struct {
+	Name     string    `json:"name"`
+	Hostname string    `json:"hostname"`
+	Kind     string    `json:"kind"`
+	IPs      []string  `json:"ips"`
+	Reported time.Time `json:"reported"`
+}
+
+// daemonState is the daemon's record of handled implants, keyed by implant
+// ID. It is saved as implants are handled so a restarted daemon leaves those
+// implants alone.
+type daemonState struct {
+	path     string
+	mu       sync.Mutex
+	Implants map[string]*implantState `json:"implants"`
+}
+
+func loadDaemonState(path string) (*daemonState, error) {
+	state := &daemonState{path: path, Implants: map[string]*implantState{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Implants == nil {
+		state.Implants = map[string]*implantState{}
+	}
+	return state, nil
+}
+
+func (s *daemonState) get(id string) (implantState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	implant, ok := s.Implants[id]
+	if !ok {
+		return implantState{}, false
+	}
+	return *implant, true
+}
+
+func (s *daemonState) put(id string, implant implantState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Implants[id] = &implant
+	if err := s.save(); err != nil {
+		log.Printf("[!] Failed to save daemon state: %s\n", err)
+	}
+}
+
+// markReported records that an implant was reported at when. The change is
+// only written out by the next flush or put.
+func (s *daemonState) markReported(id string, when time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if implant, ok := s.Implants[id]; ok {
+		implant.Reported = when
+	}
+}
+
+func (s *daemonState) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(); err != nil {
+		log.Printf("[!] Failed to save daemon state: %s\n", err)
+	}
+}
+
+// save writes the state out. The caller must hold s.mu.
+func (s *daemonState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// writeFileAtomic writes data to path through a temporary file so a crash
+// never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// daemon renames and reports every implant that calls in.
+type daemon struct {
+	servers []*server
+	opts    runOptions
+	report  Reporter
+	state   *daemonState
+
+	mu       sync.Mutex
+	inflight map[string]bool
+	wg       sync.WaitGroup
+}
+
+// RunDaemon renames every new session and beacon after its addresses and
+// reports them, re-reporting implants that are still alive every
+// interval, until ctx is done. Implants already recorded in the state file
+// are not tasked again.
+func RunDaemon(ctx context.Context, servers []*server, opts runOptions, report Reporter, statePath string, every time.Duration) {
+	state, err := loadDaemonState(statePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d := &daemon{servers: servers, opts: opts, report: report, state: state, inflight: map[string]bool{}}
+
+	go d.reportLoop(ctx, every)
+	forEachServer(ctx, servers, func(ctx context.Context, srv *server) {
+		// Pick up anything that called in while we were not listening.
+		catchUp := func() { d.catchUp(ctx, srv) }
+		watchNew(ctx, srv, catchUp, func(t Target) { d.adopt(ctx, t) })
+	})
+	d.wg.Wait()
+	opts.addrs.Report()
+}
+
+// catchUp adopts every live implant on srv the daemon has not handled yet.
+func (d *daemon) catchUp(ctx context.Context, srv *server) {
+	targets, err := findTargets([]*server{srv}, d.opts)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	for _, t := range targets {
+		if !t.IsDead() {
+			d.adopt(ctx, t)
+		}
+	}
+}
+
+// adopt gathers t's interfaces once, renames it and reports it to pwnboard,
+// unless it has been handled before or is being handled right now.
+func (d *daemon) adopt(ctx context.Context, t Target) {
+	if !d.opts.selector.Match(t) {
+		return
+	}
+	if _, ok := d.state.get(t.ID()); ok {
+		return
+	}
+	d.mu.Lock()
+	if d.inflight[t.ID()] {
+		d.mu.Unlock()
+		return
+	}
+	d.inflight[t.ID()] = true
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() {
+			d.mu.Lock()
+			delete(d.inflight, t.ID())
+			d.mu.Unlock()
+		}()
+		tctx, cancel := context.WithTimeout(ctx, d.opts.wait)
+		defer cancel()
+		runOn(tctx, d.opts, []Target{t}, ifconfig, func(o outcome) {
+			if o.err != nil {
+				log.Print(o.err)
+				return
+			}
+			ipaddr, ok := primaryIP(d.opts, t, o.reply.(*sliverpb.Ifconfig))
+			if !ok {
+				return
+			}
+			println(t.Name() + "," + t.Hostname())
+			name := renameTarget(ctx, d.opts, t, ipaddr)
+			d.report.Report(t, ipaddr)
+			d.state.put(t.ID(), implantState{
+				Name:     name,
+				Hostname: t.Hostname(),
+				Kind:     t.Kind(),
+				IPs:      []string{ipaddr},
+				Reported: time.Now(),
+			})
+		})
+	}()
+}
+
+// reportLoop re-reports the cached addresses of every handled implant that is
+// still alive, and beacons that are still checking in, each interval.
+func (d *daemon) reportLoop(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		flushReports(d.report, d.opts.dryRun)
+		targets, err := findTargets(d.servers, runOptions{})
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		now := time.Now()
+		for _, t := range targets {
+			implant, ok := d.state.get(t.ID())
+			if !ok || !alive(t, now, d.opts.late) || !d.opts.scope.Permit(t, implant.IPs...) {
+				continue
+			}
+			for _, ipaddr := range implant.IPs {
+				d.report.Report(t, ipaddr)
+			}
+			d.state.markReported(t.ID(), time.Now())
+		}
+		d.state.flush()
+	}
+}
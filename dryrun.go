@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// planRun prints what a run would do to each target instead of doing it. It
+// skips dead and out of scope targets the way runOn does, and plan describes
+// the steps that would be taken against each remaining one.
+func planRun(opts runOptions, targets []Target, plan func(Target) []string) {
+	sessions, beacons, dead := 0, 0, 0
+	for _, t := range targets {
+		if t.IsDead() {
+			dead++
+			fmt.Println("skip " + describeTarget(t) + ": dead")
+			continue
+		}
+		if !opts.scope.Permit(t) {
+			fmt.Println("skip " + describeTarget(t) + ": out of scope")
+			continue
+		}
+		if t.Kind() == kindBeacon {
+			beacons++
+		} else {
+			sessions++
+		}
+		fmt.Println(describeTarget(t))
+		for _, step := range plan(t) {
+			fmt.Println("    " + step)
+		}
+	}
+	fmt.Printf("dry run: %d sessions and %d beacons would be tasked, %d dead skipped\n", sessions, beacons, dead)
+}
+
+func describeTarget(t Target) string {
+	return fmt.Sprintf("%s %s (%s, %s, %s) on %s", t.Kind(), t.Name(), t.Hostname(), t.OS(), t.RemoteAddress(), t.Server())
+}
+
+// remoteIP is t's remote address without the port, the best guess at its
+// address before its interfaces have been gathered.
+func remoteIP(t Target) string {
+	if addr, ok := remoteAddr(t); ok {
+		return addr.String()
+	}
+	return t.RemoteAddress()
+}
+
+func planCommand(command string, args []string) func(Target) []string {
+	line := "execute " + quoteArgs(append([]string{command}, args...))
+	return func(Target) []string {
+		return []string{line}
+	}
+}
+
+func planRename(names nameTemplate) func(Target) []string {
+	return func(t Target) []string {
+		name, err := names.Render(t, remoteIP(t))
+		if err != nil {
+			name = "impossible: " + err.Error()
+		}
+		return []string{
+			"ifconfig",
+			"rename after its primary address, from its remote address that would be " + name,
+		}
+	}
+}
+
+func planPwnBoard(report Reporter) func(Target) []string {
+	return func(t Target) []string {
+		return []string{
+			"ifconfig",
+			"report its primary address to " + report.String() + ", from its remote address that would be " + remoteIP(t),
+		}
+	}
+}
+
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = fmt.Sprintf("%q", arg)
+	}
+	return strings.Join(quoted, " ")
+}
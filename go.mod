@@ -2,7 +2,10 @@ module github.com/alexcote1/Sliverer
 
 go 1.20
 
-require github.com/bishopfox/sliver v1.15.16
+require (
+	github.com/bishopfox/sliver v1.15.16
+	google.golang.org/protobuf v1.27.1
+)
 
 require (
 	github.com/golang/protobuf v1.5.2 // indirect
@@ -11,5 +14,4 @@ require (
 	golang.org/x/text v0.3.7 // indirect
 	google.golang.org/genproto v0.0.0-20210722135532-667f2b7c528f // indirect
 	google.golang.org/grpc v1.42.0-dev.0.20211020220737-f00baa6c3c84 // indirect
-	google.golang.org/protobuf v1.27.1 // indirect
 )
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bishopfox/sliver/protobuf/sliverpb"
+)
+
+// cachedIP is the address an implant was last reported as and when it was
+// gathered.
+type cachedIP struct {
+	ip       string
+	gathered time.Time
+}
+
+// heartbeat keeps pwnboard's view of which hosts we have access to fresh by
+// re-reporting every live implant each tick.
+type heartbeat struct {
+	servers []*server
+	opts    runOptions
+	report  Reporter
+	ttl     time.Duration
+	noTouch bool
+
+	mu       sync.Mutex
+	cache    map[string]cachedIP // by implant ID
+	inflight map[string]bool
+	wg       sync.WaitGroup
+}
+
+// RunHeartbeat reports every live implant to pwnboard every interval until
+// ctx is done. Addresses are gathered with ifconfig when an implant is first
+// seen and again once they are older than ttl, or never with noTouch.
+// Implants that die or stop checking in are no longer reported.
+func RunHeartbeat(ctx context.Context, servers []*server, opts runOptions, report Reporter, every time.Duration, ttl time.Duration, noTouch bool) {
+	h := &heartbeat{
+		servers:  servers,
+		opts:     opts,
+		report:   report,
+		ttl:      ttl,
+		noTouch:  noTouch,
+		cache:    map[string]cachedIP{},
+		inflight: map[string]bool{},
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		h.tick(ctx)
+		select {
+		case <-ctx.Done():
+			h.wg.Wait()
+			opts.addrs.Report()
+			return
+		case <-ticker.C:
+		}
+		flushReports(h.report, h.opts.dryRun)
+	}
+}
+
+func (h *heartbeat) tick(ctx context.Context) {
+	targets, err := findTargets(h.servers, h.opts)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	now := time.Now()
+	stale := []Target{}
+	for _, t := range targets {
+		h.mu.Lock()
+		cached, ok := h.cache[t.ID()]
+		if !alive(t, now, h.opts.late) {
+			if ok {
+				log.Printf("[*] Lost %s, no longer reporting it\n", describeTarget(t))
+				delete(h.cache, t.ID())
+			}
+			h.mu.Unlock()
+			continue
+		}
+		refreshing := h.inflight[t.ID()]
+		h.mu.Unlock()
+
+		if h.noTouch {
+			if ipaddr, ok := knownIP(h.opts, t); ok && h.opts.scope.Permit(t, ipaddr) {
+				h.report.Report(t, ipaddr)
+			}
+			continue
+		}
+		// Keep reporting the old address while a new one is gathered.
+		if ok && h.opts.scope.Allows(t, cached.ip) {
+			h.report.Report(t, cached.ip)
+		}
+		if (!ok || now.Sub(cached.gathered) > h.ttl) && !refreshing {
+			stale = append(stale, t)
+		}
+	}
+	if len(stale) > 0 {
+		h.refresh(ctx, stale)
+	}
+}
+
+// refresh gathers the addresses of targets in the background, reporting each
+// implant as soon as its address is known.
+func (h *heartbeat) refresh(ctx context.Context, targets []Target) {
+	h.mu.Lock()
+	for _, t := range targets {
+		h.inflight[t.ID()] = true
+	}
+	h.mu.Unlock()
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		defer func() {
+			h.mu.Lock()
+			for _, t := range targets {
+				delete(h.inflight, t.ID())
+			}
+			h.mu.Unlock()
+		}()
+		rctx, cancel := context.WithTimeout(ctx, h.opts.wait)
+		defer cancel()
+		runOn(rctx, h.opts, targets, ifconfig, func(o outcome) {
+			if o.err != nil {
+				log.Print(o.err)
+				return
+			}
+			t := o.target
+			ipaddr, ok := primaryIP(h.opts, t, o.reply.(*sliverpb.Ifconfig))
+			if !ok {
+				return
+			}
+			h.mu.Lock()
+			_, reported := h.cache[t.ID()]
+			h.cache[t.ID()] = cachedIP{ip: ipaddr, gathered: time.Now()}
+			h.mu.Unlock()
+			if !reported {
+				h.report.Report(t, ipaddr)
+			}
+		})
+	}()
+}
@@ -1,23 +1,19 @@
 package main
 
 import (
-	"bytes"
-	"container/list"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/bishopfox/sliver/client/assets"
 	consts "github.com/bishopfox/sliver/client/constants"
-	"github.com/bishopfox/sliver/client/transport"
 	"github.com/bishopfox/sliver/protobuf/clientpb"
 	"github.com/bishopfox/sliver/protobuf/commonpb"
 	"github.com/bishopfox/sliver/protobuf/rpcpb"
@@ -25,88 +21,106 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-type PwnBoard struct {
-	IPs  string `json:"ip"`
-	Type string `json:"type"`
-}
-
-func updatepwnBoard(ip string, urls string) {
-	// Default URL if none is provided
-	if urls == "" {
-		urls = "http://127.0.0.1"
-	}
-	// Split the urls string into a slice of URLs
-	urlList := strings.Split(urls, "^")
-
-	for _, url := range urlList {
-		// Append the endpoint to each URL
-		finalUrl := url + "/pwn/boxaccess"
-		// Create the struct
-		data := PwnBoard{
-			IPs:  ip,
-			Type: "sliver",
-		}
-
-		// Marshal the data
-		sendit, err := json.Marshal(data)
-		if err != nil {
-			fmt.Println("\n[-] ERROR SENDING POST:", err)
-			continue // Skip this iteration and proceed with the next URL
-		}
+// defaultTimeout is how long an implant may take to answer a request that
+// has no deadline of its own.
+const defaultTimeout = 60 * time.Second
 
-		// Send the post to pwnboard
-		resp, err := http.Post(finalUrl, "application/json", bytes.NewBuffer(sendit))
-		if err != nil {
-			fmt.Println("[-] ERROR SENDING POST:", err)
-			continue // Skip this iteration and proceed with the next URL
-		}
-		fmt.Println("POST sent to:", finalUrl, "Status Code:", resp.StatusCode)
-		resp.Body.Close() // Close the response body on each iteration
+// requestTimeout is the time left to answer a request made under ctx.
+func requestTimeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
 	}
+	return defaultTimeout
 }
 
-type task struct {
-	taskid string
-	beacon *clientpb.Beacon
-}
-
-func makeRequest(session *clientpb.Session) *commonpb.Request {
+// The server reads Request.Timeout as a time.Duration, not as seconds.
+func makeRequest(session *clientpb.Session, timeout time.Duration) *commonpb.Request {
 	if session == nil {
 		return nil
 	}
-	timeout := int64(60)
 	return &commonpb.Request{
 		SessionID: session.ID,
-		Timeout:   timeout,
+		Timeout:   int64(timeout),
 	}
 }
-func makeBeaconRequest(beacon *clientpb.Beacon) *commonpb.Request {
+func makeBeaconRequest(beacon *clientpb.Beacon, timeout time.Duration) *commonpb.Request {
 	if beacon == nil {
 		return nil
 	}
-	timeout := int64(60)
 	return &commonpb.Request{
 		BeaconID: beacon.ID,
-		Timeout:  timeout,
+		Timeout:  int64(timeout),
 		Async:    true,
 	}
 }
 
 func main() {
-	var configPath, argsStr, hostsStr, sessionsStr, pwnboardurl, command string
+	var configDirPath, argsStr, hostsStr, sessionsStr, pwnboardurl, command string
+	var configPaths configFlag
 	fs := flag.NewFlagSet("fs", flag.ContinueOnError)
 	fs.StringVar(&command, "command", "", "command to run")
-	fs.StringVar(&configPath, "config", "", "path to sliver client config file")
+	fs.Var(&configPaths, "config", "sliver client config: a path, an inline base64 config, or an operator or server name from ~/.sliver-client/configs (default $SLIVERER_CONFIG), repeat for several servers")
+	fs.StringVar(&configDirPath, "config-dir", "", "use every client config in this directory, one per server")
 	fs.StringVar(&argsStr, "args", "", "command args")
 	fs.StringVar(&hostsStr, "beacons", "", "runs command on list of beacons")
 	fs.StringVar(&sessionsStr, "sessions", "", "runs command on list of sessions")
 	fs.StringVar(&pwnboardurl, "url", "", "pwnboard's url")
-	var cmdArgs []string
+	var reportStr, webhookTemplate string
+	fs.StringVar(&reportStr, "report", "pwnboard", "^ separated reporters pwnboard, report and daemon send to: pwnboard, webhook=URL, file=PATH (.csv or .jsonl) and stdout")
+	fs.StringVar(&webhookTemplate, "webhook-template", defaultWebhookTemplate, "Go template of the body posted to webhook reporters, or @file to read it from")
+	var pwnboardCfg pwnboardConfig
+	fs.DurationVar(&pwnboardCfg.timeout, "pwnboard-timeout", 10*time.Second, "how long a pwnboard POST may take")
+	fs.IntVar(&pwnboardCfg.retries, "pwnboard-retries", 3, "how often a pwnboard POST is retried after a network error or 5xx")
+	fs.StringVar(&pwnboardCfg.ca, "pwnboard-ca", "", "PEM file of CA certificates to trust for pwnboard")
+	fs.BoolVar(&pwnboardCfg.insecure, "pwnboard-insecure", false, "do not verify pwnboard's TLS certificate")
+	fs.StringVar(&pwnboardCfg.token, "pwnboard-token", os.Getenv("PWNBOARD_TOKEN"), "bearer token sent to pwnboard (default $PWNBOARD_TOKEN)")
+	fs.StringVar(&pwnboardCfg.header, "pwnboard-header", "", "extra \"Name: value\" header sent to pwnboard")
+	fs.StringVar(&pwnboardCfg.spool, "spool", "sliverer-spool.json", "file failed pwnboard updates are kept in until they can be sent, empty to turn off")
+	fs.StringVar(&pwnboardCfg.path, "pwnboard-path", defaultPwnboardPath, "pwnboard endpoint updates are posted to")
+	fs.StringVar(&pwnboardCfg.types, "pwnboard-type", "sliver", "^ separated types reported to pwnboard, optionally per transport as transport=type, e.g. \"dns=sliver-dns^sliver-{kind}\"")
+	fs.StringVar(&pwnboardCfg.fields, "pwnboard-fields", "", "^ separated extra fields sent to pwnboard: hostname, implant and operator")
+	fs.StringVar(&pwnboardCfg.message, "pwnboard-message", "", "message sent to pwnboard with every update")
+	fs.StringVar(&pwnboardCfg.proxy, "pwnboard-proxy", "", "proxy URL pwnboard is reached through (default $HTTPS_PROXY or $HTTP_PROXY)")
+	var statePath string
+	var every time.Duration
+	fs.StringVar(&statePath, "state", "sliverer-state.json", "file the daemon keeps track of handled implants in")
+	fs.DurationVar(&every, "every", 5*time.Minute, "how often the daemon, or pwnboard when given, re-reports live implants to pwnboard")
+	var ttl time.Duration
+	fs.DurationVar(&ttl, "ttl", 30*time.Minute, "how long pwnboard --every reuses an implant's addresses before gathering them again")
+	var opts runOptions
+	fs.DurationVar(&opts.wait, "wait", 15*time.Minute, "how long to wait for beacons to return results")
+	fs.IntVar(&opts.parallel, "parallel", 10, "how many implants to task at once")
+	fs.DurationVar(&opts.timeout, "timeout", defaultTimeout, "how long a session may take to answer")
+	fs.StringVar(&opts.output, "output", "text", "command output format: text, json or ndjson")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print what command, rename or pwnboard would do without tasking anything")
+	var noTouch bool
+	fs.IntVar(&opts.late, "late", 2, "how many intervals may pass since a beacon's last check-in before it counts as lost")
+	fs.BoolVar(&noTouch, "no-touch", false, "pwnboard reports the addresses the server already knows instead of tasking implants")
+	fs.BoolVar(&opts.yes, "yes", false, "run the command without asking for confirmation")
+	fs.IntVar(&opts.confirmOver, "confirm-over", 10, "ask for confirmation before running a command on more implants than this")
+	var dangerousStr string
+	fs.StringVar(&dangerousStr, "dangerous", "", "^ separated regexps of commands that always need confirmation, replacing the built in list")
+	var nameTemplateStr string
+	var teamOctet int
+	fs.StringVar(&nameTemplateStr, "name-template", defaultNameTemplate, "implant name rename gives each address, using {ip}, {hostname}, {short_hostname}, {os}, {user}, {team}, {transport} and {kind}")
+	fs.IntVar(&teamOctet, "team-octet", 2, "which octet of the address is {team}")
+	var preferStr, skipIfacesStr, skipCIDRsStr, includeStr, ipv6 string
+	fs.StringVar(&ipv6, "ipv6", "off", "which IPv6 addresses rename and pwnboard may use: off, global (global and unique local) or all (also link-local)")
+	fs.StringVar(&skipIfacesStr, "skip-ifaces", defaultSkipIfaces, "^ separated globs of interfaces whose addresses rename and pwnboard ignore")
+	fs.StringVar(&skipCIDRsStr, "skip-cidrs", defaultSkipCIDRs, "^ separated addresses and CIDRs rename and pwnboard ignore")
+	fs.StringVar(&includeStr, "include", "", "^ separated addresses and CIDRs rename and pwnboard always use, even on skipped interfaces")
+	fs.StringVar(&preferStr, "prefer", "", "^ separated CIDRs, in priority order, to pick a host's primary address from")
+	var selectStr, scopePath, auditPath, notifyPath, metricsAddr string
+	fs.StringVar(&metricsAddr, "metrics", "", "address to serve Prometheus metrics on in watch, daemon, notify, metrics (default "+defaultMetricsAddr+") and pwnboard --every. a bare :port listens on localhost only, give a host such as 0.0.0.0:9100 to listen elsewhere")
+	fs.StringVar(&notifyPath, "notify", "", "JSON file of Slack, Mattermost and Discord webhooks to notify about implants and command runs")
+	fs.StringVar(&auditPath, "audit", "sliverer-audit.jsonl", "file every action taken against implants is appended to, empty to turn off")
+	fs.StringVar(&scopePath, "scope", "", "JSON file of allowed and denied CIDRs and hostnames, implants outside it are never tasked")
+	fs.StringVar(&selectStr, "select", "", "only act on implants matching this selector, e.g. \"os=linux and ip=10.5.0.0/16 and checkin<5m\"")
+	var cmdArgs, positional []string
 	//allow for any postion.
-	subcommand := ""
 	for _, arg := range os.Args[1:] {
 		if !strings.HasPrefix(arg, "-") {
-			subcommand = arg
+			positional = append(positional, arg)
 
 		} else {
 			cmdArgs = append(cmdArgs, arg)
@@ -116,36 +130,149 @@ func main() {
 		fmt.Println("Error parsing flags:", err)
 		os.Exit(1)
 	}
+	everySet := false
+	fs.Visit(func(f *flag.Flag) { everySet = everySet || f.Name == "every" })
+	// The subcommand comes first, followed by its own subcommand if it has one.
+	subcommand, action := "", ""
+	if len(positional) > 0 {
+		subcommand = positional[0]
+	}
+	if len(positional) > 1 {
+		action = positional[1]
+	}
+	// report is pwnboard under a name that suits other reporters.
+	if subcommand == "report" {
+		subcommand = "pwnboard"
+	}
+
+	if !isinarray(outputFormats, opts.output) {
+		fmt.Println("Expected --output to be one of", strings.Join(outputFormats, ", "))
+		os.Exit(1)
+	}
+	if every <= 0 {
+		fmt.Println("Expected --every to be positive")
+		os.Exit(1)
+	}
+	if ttl <= 0 {
+		fmt.Println("Expected --ttl to be positive")
+		os.Exit(1)
+	}
+	// daemon and watch task implants as they call in, there is no plan to print.
+	if opts.dryRun && (subcommand == "daemon" || subcommand == "watch") {
+		fmt.Println("--dry-run does not work with '" + subcommand + "', it only plans command, rename and pwnboard")
+		os.Exit(1)
+	}
+
+	sel, err := parseSelector(selectStr)
+	if err != nil {
+		fmt.Println("Error parsing --select:", err)
+		os.Exit(1)
+	}
+	opts.selector = sel
+	opts.names, err = parseNameTemplate(nameTemplateStr, teamOctet)
+	if err != nil {
+		fmt.Println("Error parsing --name-template:", err)
+		os.Exit(1)
+	}
+	opts.filter, err = parseAddrFilter(skipIfacesStr, skipCIDRsStr, includeStr, ipv6)
+	if err != nil {
+		fmt.Println("Error parsing address filters:", err)
+		os.Exit(1)
+	}
+	opts.addrs, err = parseAddrPolicy(preferStr)
+	if err != nil {
+		fmt.Println("Error parsing --prefer:", err)
+		os.Exit(1)
+	}
+	pwnboard, err = newPwnboardClient(pwnboardCfg)
+	if err != nil {
+		fmt.Println("Error setting up pwnboard client:", err)
+		os.Exit(1)
+	}
+	defer pwnboard.Summary()
+	reporter, err := parseReporters(reportStr, pwnboardurl, webhookTemplate)
+	if err != nil {
+		fmt.Println("Error parsing --report:", err)
+		os.Exit(1)
+	}
+	defer reporter.Close()
+	opts.dangerous, err = parseDangerous(dangerousStr)
+	if err != nil {
+		fmt.Println("Error parsing --dangerous:", err)
+		os.Exit(1)
+	}
+	if scopePath != "" {
+		opts.scope, err = loadScope(scopePath)
+		if err != nil {
+			fmt.Println("Error loading --scope:", err)
+			os.Exit(1)
+		}
+		defer opts.scope.Summary()
+	}
+	if notifyPath != "" {
+		notifier, err = loadNotifier(notifyPath)
+		if err != nil {
+			fmt.Println("Error loading --notify:", err)
+			os.Exit(1)
+		}
+		defer notifier.Close()
+	}
 
 	args := strings.Split(argsStr, "^")
 	hosts := strings.Split(hostsStr, " ")
 	sessions := strings.Split(sessionsStr, " ")
 
-	if configPath == "" {
-		println("No config is provided --config would work, but attempting to guess based on what's in ~/.sliver-client/configs/")
-		files, err := ioutil.ReadDir(os.Getenv("HOME") + "/.sliver-client/configs/")
+	if subcommand == "configs" {
+		ListConfigs()
+		return
+	}
+
+	// startAudit opens the audit log, recording the run as operator's.
+	startAudit := func(operator string) {
+		if auditPath == "" {
+			return
+		}
+		audit, err = openAuditLog(auditPath, operator)
 		if err != nil {
 			log.Fatal(err)
 		}
-		configPath = os.Getenv("HOME") + "/.sliver-client/configs/" + files[0].Name()
+		audit.Run(auditArgs(os.Args[1:], map[string]func(string) string{
+			"config":          configName,
+			"pwnboard-token":  redactSecret,
+			"pwnboard-header": redactHeader,
+		}))
+	}
+	defer func() { audit.Close() }()
+
+	// Flushing the spool only talks to pwnboard, so it needs no team server.
+	if subcommand == "pwnboard" && action == "flush" {
+		startAudit(localOperator())
+		pwnboard.Flush(opts.dryRun)
+		return
 	}
 
-	// Load the client configuration from the filesystem
-	config, err := assets.ReadConfig(configPath)
+	// Find the client configurations
+	configs, err := resolveConfigs(configPaths, configDirPath)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Println("Error finding a config:", err)
+		os.Exit(1)
 	}
 
-	// Connect to the server
-	rpc, ln, err := transport.MTLSConnect(config)
-	if err != nil {
-		log.Fatal(err)
+	// Connect to the servers
+	servers := connectServers(configs)
+	if len(servers) == 0 {
+		log.Fatal("Could not connect to any sliver server")
+	}
+	defer closeServers(servers)
+	startAudit(operators(servers))
+
+	if subcommand == "pwnboard" || subcommand == "daemon" {
+		// Send whatever earlier runs could not before reporting anything new.
+		flushReports(reporter, opts.dryRun)
 	}
-	log.Println("[*] Connected to sliver server")
-	defer ln.Close()
 
 	if len(os.Args) < 2 {
-		fmt.Println("Expected 'rename', 'pwnboard','command'")
+		fmt.Println("Expected 'rename', 'pwnboard','report','command','watch','daemon','notify','metrics','configs'")
 		os.Exit(1)
 	}
 	// subcommand := ""
@@ -156,67 +283,99 @@ func main() {
 	// 	}
 	// }
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Long running modes also notify about implants and serve metrics.
+	// A dry run of pwnboard --every shows one round of what it would report.
+	heartbeat := subcommand == "pwnboard" && everySet && !opts.dryRun
+	if subcommand == "pwnboard" && everySet && opts.dryRun {
+		fmt.Println("dry run: pwnboard --every would do this every " + every.String())
+	}
+	longRunning := subcommand == "daemon" || subcommand == "watch" || subcommand == "notify" || subcommand == "metrics" || heartbeat
+	if subcommand == "metrics" && metricsAddr == "" {
+		metricsAddr = defaultMetricsAddr
+	}
+	if longRunning && metricsAddr != "" {
+		go ServeMetrics(ctx, metricsAddr, servers, opts.late)
+	}
+	if subcommand == "notify" || subcommand == "metrics" {
+		if subcommand == "notify" && notifier == nil {
+			fmt.Println("Expected 'notify' with --notify")
+			return
+		}
+		RunNotifier(ctx, servers)
+		<-ctx.Done()
+		return
+	}
+	if longRunning {
+		go RunNotifier(ctx, servers)
+	}
+	if subcommand == "daemon" {
+		RunDaemon(ctx, servers, opts, reporter, statePath, every)
+		return
+	}
+	if heartbeat {
+		RunHeartbeat(ctx, servers, opts, reporter, every, ttl, noTouch)
+		return
+	}
+	if subcommand == "watch" {
+		if command == "" {
+			fmt.Println("Expected 'watch' with --command")
+			return
+		}
+		RunCommandOnNew(ctx, servers, opts, command, args)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.wait)
+	defer cancel()
+
 	switch subcommand {
 	case "rename":
-		RenameAll(rpc)
+		RenameAll(ctx, servers, opts)
 	case "pwnboard":
-		SendToPwnBoard(rpc, pwnboardurl)
+		if noTouch {
+			SendToPwnBoardNoTouch(servers, opts, reporter)
+		} else {
+			SendToPwnBoard(ctx, servers, opts, reporter)
+		}
 	case "command":
 		if command == "" {
 			fmt.Println("Expected 'command' with args")
 			return
 		}
 		if sessionsStr != "" {
-			RunCommandOnSessionList(rpc, command, args, sessions)
+			RunCommandOnSessionList(ctx, servers, opts, command, args, sessions)
 		} else if hostsStr != "" {
-			RunCommandOnBeaconList(rpc, command, args, hosts)
+			RunCommandOnBeaconList(ctx, servers, opts, command, args, hosts)
 		} else {
-			RunCommandonAll(rpc, command, args)
+			RunCommandonAll(ctx, servers, opts, command, args)
 		}
 	}
 
 }
 
-func RunCommandOnSessionList(rpc rpcpb.SliverRPCClient, command string, args []string, hosts []string) {
-	sessions, err := rpc.GetSessions(context.Background(), &commonpb.Empty{})
+func RunCommandOnSessionList(ctx context.Context, servers []*server, opts runOptions, command string, args []string, hosts []string) {
+	targets, err := findTargets(servers, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	runon := []*clientpb.Session{}
-
-	for i := 0; i < len(sessions.Sessions); i++ {
-		if isinarray(hosts, sessions.Sessions[i].Name) {
-			runon = append(runon, sessions.Sessions[i])
-		}
+	selected := selectTargets(targets, kindSession, hosts)
+	if len(selected) == 0 {
+		log.Println("[!] --sessions matched no sessions")
 	}
-	//print(&agents.Sessions[i])
-	//println(i)
-
-	for i := 0; i < len(runon); i++ {
-		runcommandon(rpc, command, runon[i], args)
-	}
-
+	runCommand(ctx, opts, selected, command, args)
 }
 
-func RunCommandOnBeaconList(rpc rpcpb.SliverRPCClient, command string, args []string, hosts []string) {
-	beacons, err := rpc.GetBeacons(context.Background(), &commonpb.Empty{})
+func RunCommandOnBeaconList(ctx context.Context, servers []*server, opts runOptions, command string, args []string, hosts []string) {
+	targets, err := findTargets(servers, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	runon := []*clientpb.Beacon{}
-
-	for i := 0; i < len(beacons.Beacons); i++ {
-		if isinarray(hosts, beacons.Beacons[i].Name) {
-			runon = append(runon, beacons.Beacons[i])
-		}
+	selected := selectTargets(targets, kindBeacon, hosts)
+	if len(selected) == 0 {
+		log.Println("[!] --beacons matched no beacons")
 	}
-	//print(&agents.Sessions[i])
-	//println(i)
-	beacons.Beacons = runon
-	runonbeacons(beacons, rpc, command, args)
-
+	runCommand(ctx, opts, selected, command, args)
 }
 
 func isinarray(hosts []string, host string) bool {
@@ -251,487 +410,262 @@ func GetSessions(rpc rpcpb.SliverRPCClient) {
 	}
 }
 
-func RunCommandonAll(rpc rpcpb.SliverRPCClient, command string, args []string) {
-	agents, err := rpc.GetSessions(context.Background(), &commonpb.Empty{})
+func RunCommandonAll(ctx context.Context, servers []*server, opts runOptions, command string, args []string) {
+	targets, err := findTargets(servers, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-	//print(agents)
-	for i := 0; i < len(agents.Sessions); i++ {
-		//print(&agents.Sessions[i])
-		if agents.Sessions[i].IsDead == true {
-			println(agents.Sessions[i].Hostname + " is dead")
-		} else {
-			//println(i)
-			runcommandon(rpc, command, agents.Sessions[i], args)
-		}
-	}
-	beacons, err := rpc.GetBeacons(context.Background(), &commonpb.Empty{})
-	if err != nil {
-		log.Fatal(err)
-	}
-	//print(&agents.Sessions[i])
-	//println(i)
-	runonbeacons(beacons, rpc, command, args)
+	runCommand(ctx, opts, targets, command, args)
 }
-func RenameAll(rpc rpcpb.SliverRPCClient) {
-	agents, err := rpc.GetSessions(context.Background(), &commonpb.Empty{})
-	if err != nil {
-		log.Fatal(err)
-	}
-	for i := 0; i < len(agents.Sessions); i++ {
-		ifconfig, err := rpc.Ifconfig(context.Background(), &sliverpb.IfconfigReq{
-			Request: makeRequest(agents.Sessions[i]),
-		})
-		if err != nil {
-			log.Print(err)
-			continue
-		}
-		println(agents.Sessions[i].Name + "," + agents.Sessions[i].Hostname)
-		for g := 0; g < len(ifconfig.NetInterfaces); g++ {
-			if ifconfig.NetInterfaces[g].Name != "lo" {
-				for k := 0; k < len(ifconfig.NetInterfaces[g].IPAddresses); k++ {
-					if !strings.Contains(ifconfig.NetInterfaces[g].IPAddresses[k], ":") && !strings.Contains(ifconfig.NetInterfaces[g].IPAddresses[k], "172.17.0.1") && !strings.Contains(ifconfig.NetInterfaces[g].IPAddresses[k], "127.0.0.1") {
-						println(ifconfig.NetInterfaces[g].IPAddresses[k])
-						ipaddr := ifconfig.NetInterfaces[g].IPAddresses[k]
-						ipaddr = strings.Split(ipaddr, "/")[0]
-						name := ipaddr + "_" + agents.Sessions[i].Hostname + "."
-						println(name)
-						_, err := rpc.Rename(context.Background(), &clientpb.RenameReq{
-							SessionID: agents.Sessions[i].ID,
-							Name:      name,
-						})
-
-						if err != nil {
-							log.Print("Failed to decode task response: %s\n", err)
-
-						}
-					}
-				}
-			}
-		}
 
-	}
-
-	beacons, err := rpc.GetBeacons(context.Background(), &commonpb.Empty{})
+func RenameAll(ctx context.Context, servers []*server, opts runOptions) {
+	targets, err := findTargets(servers, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-	taskids := list.New()
-	for i := 0; i < len(beacons.Beacons); i++ {
-		if beacons.Beacons[i].IsDead == true {
-			println(beacons.Beacons[i].Hostname + " is dead")
-		} else {
-
-			ifconfig, err := rpc.Ifconfig(context.Background(), &sliverpb.IfconfigReq{
-				Request: makeBeaconRequest(beacons.Beacons[i]),
-			})
-			if err != nil {
-				log.Print(err)
-			}
-			taskids.PushFront(task{taskid: ifconfig.Response.TaskID, beacon: beacons.Beacons[i]})
-		}
+	if opts.dryRun {
+		planRun(opts, targets, planRename(opts.names))
+		return
 	}
-
-	for k := 0; k < 100; k++ {
-		if taskids.Front() == nil {
-			continue
+	runOn(ctx, opts, targets, ifconfig, func(o outcome) {
+		if o.err != nil {
+			log.Print(o.err)
+			return
 		}
-		log.Println("waiting 10 seconds")
-		time.Sleep(10 * time.Second)
-		for i := taskids.Front(); i != nil; i = i.Next() {
-
-			tasks, err := rpc.GetBeaconTasks(context.Background(), (i.Value).(task).beacon)
-
-			if err != nil {
-				log.Print(err)
-			}
-			for j := 0; j < len(tasks.Tasks); j++ {
-				if tasks.Tasks[j].State == "completed" {
-					if tasks.Tasks[j].ID == (i.Value).(task).taskid {
-						resp, err := rpc.GetBeaconTaskContent(context.Background(), tasks.Tasks[j])
-						if err != nil {
-							log.Print(err)
-						}
-						oldval := i.Value
-						old := i.Prev()
-						if old == nil {
-							println("HELP")
-							if i.Next() == nil {
-								taskids.Remove(i)
-								i = nil
-							} else {
-								taskids.MoveToFront(i.Next())
-								taskids.Remove(i)
-								i = taskids.Front()
-							}
-
-						} else {
-							taskids.Remove(i)
-							i = old
-						}
-
-						ifconfig := &sliverpb.Ifconfig{}
-						err = proto.Unmarshal(resp.Response, ifconfig)
-						if err != nil {
-							log.Print("Failed to decode task response: %s\n", err)
-
-						}
-
-						println((oldval).(task).beacon.Name + "," + (oldval).(task).beacon.Hostname)
-						for g := 0; g < len(ifconfig.NetInterfaces); g++ {
-							if ifconfig.NetInterfaces[g].Name != "lo" {
-								for k := 0; k < len(ifconfig.NetInterfaces[g].IPAddresses); k++ {
-									if !strings.Contains(ifconfig.NetInterfaces[g].IPAddresses[k], ":") && !strings.Contains(ifconfig.NetInterfaces[g].IPAddresses[k], "172.17.0.1") {
-										println(ifconfig.NetInterfaces[g].IPAddresses[k])
-										ipaddr := ifconfig.NetInterfaces[g].IPAddresses[k]
-										ipaddr = strings.Split(ipaddr, "/")[0]
-										name := ipaddr + "_" + (oldval).(task).beacon.Hostname + "."
-										if len(name) > 32 {
-											name = name[:32] // Truncate to the first 32 characters
-										}
-										println(name)
-										_, err := rpc.Rename(context.Background(), &clientpb.RenameReq{
-											BeaconID: (oldval).(task).beacon.ID,
-											Name:     name,
-										})
-
-										if err != nil {
-											log.Print("Failed to decode task response: %s\n", err)
-
-										}
-									}
-								}
-							}
-						}
-						//println(string(resp.Response))
-					}
-				}
-			}
-			if i == nil {
-				println("i think i got everyone")
-				break
-			}
+		t := o.target
+		ipaddr, ok := primaryIP(opts, t, o.reply.(*sliverpb.Ifconfig))
+		if !ok {
+			return
 		}
+		println(t.Name() + "," + t.Hostname())
+		renameTarget(ctx, opts, t, ipaddr)
+	})
+	opts.addrs.Report()
+}
 
+// primaryIP picks the address t is renamed after and reported as from its
+// interfaces, once every interface address, filtered or not, has been checked
+// against the scope.
+func primaryIP(opts runOptions, t Target, ifconfig *sliverpb.Ifconfig) (string, bool) {
+	if !opts.scope.Permit(t, ifconfigIPs(ifconfig)...) {
+		return "", false
 	}
-	for i := taskids.Front(); i != nil; i = i.Next() {
-		println("didnt hear from " + (i.Value).(task).beacon.Name + "," + (i.Value).(task).beacon.Hostname)
+	addrs := opts.filter.Addrs(ifconfig)
+	ipaddr, ok := opts.addrs.Primary(t, addrs)
+	if !ok {
+		log.Printf("[!] %s,%s has no usable address\n", t.Name(), t.Hostname())
 	}
+	return ipaddr, ok
+}
 
+// renameTarget names t after ipaddr using opts.names and returns the name.
+func renameTarget(ctx context.Context, opts runOptions, t Target, ipaddr string) string {
+	println(ipaddr)
+	name, err := opts.names.Render(t, ipaddr)
+	if err != nil {
+		audit.Action(t, auditEntry{Action: "rename"}, err)
+		counters.inc("sliverer_renames_total", outcomeLabel(err))
+		log.Printf("[!] Not renaming %s: %s\n", t.Name(), err)
+		return t.Name()
+	}
+	println(name)
+	_, err = t.RPC().Rename(ctx, t.RenameReq(name))
+	audit.Action(t, auditEntry{Action: "rename", Name: name}, err)
+	counters.inc("sliverer_renames_total", outcomeLabel(err))
+	if err != nil {
+		log.Printf("Failed to rename %s: %s\n", t.Name(), err)
+	}
+	return name
 }
-func SendToPwnBoard(rpc rpcpb.SliverRPCClient, url string) {
-	agents, err := rpc.GetSessions(context.Background(), &commonpb.Empty{})
+
+func SendToPwnBoard(ctx context.Context, servers []*server, opts runOptions, report Reporter) {
+	targets, err := findTargets(servers, opts)
 	if err != nil {
 		log.Fatal(err)
 	}
-	for i := 0; i < len(agents.Sessions); i++ {
-		ifconfig, err := rpc.Ifconfig(context.Background(), &sliverpb.IfconfigReq{
-			Request: makeRequest(agents.Sessions[i]),
-		})
-		if err != nil {
-			log.Print(err)
-			continue
+	if opts.dryRun {
+		planRun(opts, targets, planPwnBoard(report))
+		return
+	}
+	runOn(ctx, opts, targets, ifconfig, func(o outcome) {
+		if o.err != nil {
+			log.Print(o.err)
+			return
 		}
-		println(agents.Sessions[i].Name + "," + agents.Sessions[i].Hostname)
-		for g := 0; g < len(ifconfig.NetInterfaces); g++ {
-			if ifconfig.NetInterfaces[g].Name != "lo" {
-				for k := 0; k < len(ifconfig.NetInterfaces[g].IPAddresses); k++ {
-					if !strings.Contains(ifconfig.NetInterfaces[g].IPAddresses[k], ":") && !strings.Contains(ifconfig.NetInterfaces[g].IPAddresses[k], "172.17.0.1") {
-						println(ifconfig.NetInterfaces[g].IPAddresses[k])
-						ipaddr := ifconfig.NetInterfaces[g].IPAddresses[k]
-						ipaddr = strings.Split(ipaddr, "/")[0]
-						name := ipaddr + "_" + agents.Sessions[i].Hostname + "."
-						println(name)
-
-						// _, err := rpc.Rename(context.Background(), &clientpb.RenameReq{
-						// 	SessionID: agents.Sessions[i].ID,
-						// 	Name:      name,
-						//})
-						updatepwnBoard(ipaddr, url)
-						//todo upload to pwnboard
-						if err != nil {
-							log.Print("Failed to decode task response: %s\n", err)
-
-						}
-					}
-				}
-			}
+		t := o.target
+		ipaddr, ok := primaryIP(opts, t, o.reply.(*sliverpb.Ifconfig))
+		if !ok {
+			return
 		}
+		println(t.Name() + "," + t.Hostname())
+		println(ipaddr)
+		report.Report(t, ipaddr)
+	})
+	opts.addrs.Report()
+}
 
+func ifconfig(ctx context.Context, t Target) (reply, error) {
+	resp, err := t.RPC().Ifconfig(ctx, &sliverpb.IfconfigReq{
+		Request: t.Request(ctx),
+	})
+	if err != nil {
+		audit.Action(t, auditEntry{Action: "ifconfig"}, err)
+		return nil, err
 	}
+	audit.Action(t, auditEntry{Action: "ifconfig", TaskID: resp.GetResponse().GetTaskID(), Outcome: "issued"}, nil)
+	return resp, nil
+}
 
-	beacons, err := rpc.GetBeacons(context.Background(), &commonpb.Empty{})
+func execute(ctx context.Context, t Target, command string, args []string) (reply, error) {
+	resp, err := t.RPC().Execute(ctx, &sliverpb.ExecuteReq{
+		Path:    command,
+		Output:  true,
+		Args:    args,
+		Request: t.Request(ctx),
+	})
+	entry := auditEntry{Action: "execute", Command: command, Args: args, Outcome: "issued"}
 	if err != nil {
-		log.Fatal(err)
+		audit.Action(t, entry, err)
+		return nil, err
 	}
-	taskids := list.New()
-	for i := 0; i < len(beacons.Beacons); i++ {
-		if beacons.Beacons[i].IsDead == true {
-			println(beacons.Beacons[i].Hostname + " is dead")
-		} else {
-
-			ifconfig, err := rpc.Ifconfig(context.Background(), &sliverpb.IfconfigReq{
-				Request: makeBeaconRequest(beacons.Beacons[i]),
-			})
-			if err != nil {
-				log.Print(err)
-			}
-			taskids.PushFront(task{taskid: ifconfig.Response.TaskID, beacon: beacons.Beacons[i]})
-		}
+	entry.TaskID = resp.GetResponse().GetTaskID()
+	audit.Action(t, entry, nil)
+	if t.Kind() == kindBeacon {
+		log.Println("[*] Beacon:" + t.Hostname() + " going to check back in with this beacon")
 	}
+	return resp, nil
+}
 
-	for k := 0; k < 100; k++ {
-		if taskids.Front() == nil {
-			continue
+// runCommand executes command on every target and prints each host's output
+// in the format asked for by opts.output.
+func runCommand(ctx context.Context, opts runOptions, targets []Target, command string, args []string) {
+	if opts.dryRun {
+		planRun(opts, targets, planCommand(command, args))
+		return
+	}
+	if !confirmCommand(opts, targets, command, args) {
+		log.Println("[*] Not confirmed, nothing was run")
+		return
+	}
+	issue := func(ctx context.Context, t Target) (reply, error) {
+		return execute(ctx, t, command, args)
+	}
+	out := newResultWriter(opts.output)
+	ok, failed := 0, 0
+	runOn(ctx, opts, targets, issue, func(o outcome) {
+		if o.err != nil {
+			failed++
+		} else {
+			ok++
 		}
-		log.Println("waiting 10 seconds")
-		time.Sleep(10 * time.Second)
-		for i := taskids.Front(); i != nil; i = i.Next() {
-
-			tasks, err := rpc.GetBeaconTasks(context.Background(), (i.Value).(task).beacon)
+		counters.inc("sliverer_commands_total", outcomeLabel(o.err))
+		out.Write(o)
+	})
+	if err := out.Close(); err != nil {
+		log.Print(err)
+	}
+	notifier.Command(command, args, ok, failed)
+}
 
-			if err != nil {
-				log.Print(err)
-			}
-			for j := 0; j < len(tasks.Tasks); j++ {
-				if tasks.Tasks[j].State == "completed" {
-					if tasks.Tasks[j].ID == (i.Value).(task).taskid {
-						resp, err := rpc.GetBeaconTaskContent(context.Background(), tasks.Tasks[j])
-						if err != nil {
-							log.Print(err)
-						}
-						oldval := i.Value
-						old := i.Prev()
-						if old == nil {
-							println("HELP")
-							if i.Next() == nil {
-								taskids.Remove(i)
-								i = nil
-							} else {
-								taskids.MoveToFront(i.Next())
-								taskids.Remove(i)
-								i = taskids.Front()
-							}
-
-						} else {
-							taskids.Remove(i)
-							i = old
-						}
-
-						ifconfig := &sliverpb.Ifconfig{}
-						err = proto.Unmarshal(resp.Response, ifconfig)
-						if err != nil {
-							log.Print("Failed to decode task response: %s\n", err)
-
-						}
-
-						println((oldval).(task).beacon.Name + "," + (oldval).(task).beacon.Hostname)
-						for g := 0; g < len(ifconfig.NetInterfaces); g++ {
-							if ifconfig.NetInterfaces[g].Name != "lo" {
-								for k := 0; k < len(ifconfig.NetInterfaces[g].IPAddresses); k++ {
-									if !strings.Contains(ifconfig.NetInterfaces[g].IPAddresses[k], ":") && !strings.Contains(ifconfig.NetInterfaces[g].IPAddresses[k], "172.17.0.1") {
-										println(ifconfig.NetInterfaces[g].IPAddresses[k])
-										ipaddr := ifconfig.NetInterfaces[g].IPAddresses[k]
-										ipaddr = strings.Split(ipaddr, "/")[0]
-										name := ipaddr + "_" + (oldval).(task).beacon.Hostname + "."
-										println(name)
-										// _, err := rpc.Rename(context.Background(), &clientpb.RenameReq{
-										// 	BeaconID: (oldval).(task).beacon.ID,
-										// 	Name:     name,
-										// })
-
-										updatepwnBoard(ipaddr, url)
-										if err != nil {
-											log.Print("Failed to decode task response: %s\n", err)
-
-										}
-									}
-								}
-							}
-						}
-						//println(string(resp.Response))
-					}
-				}
-			}
-			if i == nil {
-				println("i think i got everyone")
-				break
-			}
+// RunCommandOnNew runs command on every session that opens and every beacon
+// that registers until ctx is done. Each new beacon is given opts.wait to
+// check in with its result.
+func RunCommandOnNew(ctx context.Context, servers []*server, opts runOptions, command string, args []string) {
+	issue := func(ctx context.Context, t Target) (reply, error) {
+		return execute(ctx, t, command, args)
+	}
+	out := newResultWriter(opts.output)
+	var mu sync.Mutex
+	write := func(o outcome) {
+		mu.Lock()
+		defer mu.Unlock()
+		counters.inc("sliverer_commands_total", outcomeLabel(o.err))
+		out.Write(o)
+	}
+
+	var wg sync.WaitGroup
+	runOnNew := func(t Target) {
+		if !opts.selector.Match(t) {
+			return
 		}
-
-	}
-	for i := taskids.Front(); i != nil; i = i.Next() {
-		println("didnt hear from " + (i.Value).(task).beacon.Name + "," + (i.Value).(task).beacon.Hostname)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tctx, cancel := context.WithTimeout(ctx, opts.wait)
+			defer cancel()
+			runOn(tctx, opts, []Target{t}, issue, write)
+		}()
 	}
 
+	forEachServer(ctx, servers, func(ctx context.Context, srv *server) {
+		watchNew(ctx, srv, nil, runOnNew)
+	})
+	wg.Wait()
+	if err := out.Close(); err != nil {
+		log.Print(err)
+	}
 }
 
-//todo
-// func SendToPwnBoardNoTouch(rpc rpcpb.SliverRPCClient, url string){
-// 	beacons, err := rpc.GetBeacons(context.Background(), &commonpb.Empty{})
-// 	if err != nil {
-// 		log.Fatal(err)
-// 	}
-// 	sessions, err := rpc.GetSessions(context.Background(), &commonpb.Empty{})
-// 	if err != nil {
-// 		log.Fatal(err)
-// 	}
-// 	for i := 0; i < len(sessions.Sessions); i++ {
-// 		if sessions.Sessions[i].IsDead == true  {
-// 			continue
-// 		}
-// 		if sessions.Sessions[i].LastCheckin.Seconds >0 {
-
-// }
-// return
-// }
-// }
-
-func runonbeacons(beacons *clientpb.Beacons, rpc rpcpb.SliverRPCClient, command string, args []string) {
-	taskids := list.New()
-	for i := 0; i < len(beacons.Beacons); i++ {
-
-		if beacons.Beacons[i].IsDead == true {
-			println(beacons.Beacons[i].Hostname + " is dead")
-		} else {
-
-			taskids.PushFront(runcommandonbeacon(rpc, command, beacons.Beacons[i], args))
+// watchNew calls fn with every session that opens and every beacon that
+// registers on srv until ctx is done, reconnecting the event stream whenever
+// it drops. If connected is not nil it is called each time the stream is
+// (re)opened.
+func watchNew(ctx context.Context, srv *server, connected func(), fn func(Target)) {
+	watchImplants(ctx, srv, connected, func(eventType string, t Target) {
+		if eventType != consts.SessionClosedEvent {
+			fn(t)
 		}
-	}
-	for i := 0; i < 100; i++ {
-		if taskids.Front() == nil {
+	})
+}
+
+// watchImplants calls fn with the event type and implant of every session
+// that opens or closes and every beacon that registers on srv, the way
+// watchNew does.
+func watchImplants(ctx context.Context, srv *server, connected func(), fn func(string, Target)) {
+	for ctx.Err() == nil {
+		// Open the event stream to be able to collect all events sent by  the server
+		eventStream, err := srv.rpc.Events(ctx, &commonpb.Empty{})
+		if err != nil {
+			log.Printf("[!] %s: %s\n", srv.name, err)
+			sleep(ctx, pollInterval)
 			continue
 		}
-		log.Println("waiting 10 seconds")
-		time.Sleep(10 * time.Second)
-		for i := taskids.Front(); i != nil; i = i.Next() {
-			tasks, err := rpc.GetBeaconTasks(context.Background(), (i.Value).(task).beacon)
+		log.Println("[*] Watching for new sessions and beacons on " + srv.name)
+		if connected != nil {
+			connected()
+		}
+		for {
+			event, err := eventStream.Recv()
 			if err != nil {
-				log.Print(err)
-			}
-			for j := 0; j < len(tasks.Tasks); j++ {
-				if tasks.Tasks[j].State == "completed" {
-					if tasks.Tasks[j].ID == (i.Value).(task).taskid {
-						resp, err := rpc.GetBeaconTaskContent(context.Background(), tasks.Tasks[j])
-						if err != nil {
-							log.Print(err)
-						}
-						oldval := i.Value
-						old := i.Prev()
-						if old == nil {
-							println("HELP")
-							if i.Next() == nil {
-								taskids.Remove(i)
-								i = nil
-							} else {
-								taskids.MoveToFront(i.Next())
-								taskids.Remove(i)
-								i = taskids.Front()
-							}
-
-						} else {
-							taskids.Remove(i)
-							i = old
-						}
-
-						println((oldval).(task).beacon.Name + "," + (oldval).(task).beacon.Hostname)
-						command := &sliverpb.Execute{}
-						err = proto.Unmarshal(resp.Response, command)
-						if err != nil {
-							log.Print("Failed to decode task response: %s\n", err)
-
-						}
-						println(string(command.Stdout))
-						println(string(command.Stderr))
-					}
+				if err != io.EOF && ctx.Err() == nil {
+					log.Printf("[!] Lost event stream from %s (%s), reconnecting", srv.name, err)
 				}
-			}
-			if i == nil {
-				println("i think i got everyone")
 				break
 			}
+			// Trigger event based on type
+			switch event.EventType {
+
+			// a new session just came in, or one went away
+			case consts.SessionOpenedEvent, consts.SessionClosedEvent:
+				fn(event.EventType, newSessionTarget(srv, event.Session))
+
+			// a new beacon registered, its details are in the event data
+			case consts.BeaconRegisteredEvent:
+				beacon := &clientpb.Beacon{}
+				if err := proto.Unmarshal(event.Data, beacon); err != nil {
+					log.Printf("Failed to decode beacon: %s\n", err)
+					continue
+				}
+				fn(event.EventType, newBeaconTarget(srv, beacon))
+			}
 		}
 	}
-	for i := taskids.Front(); i != nil; i = i.Next() {
-		println("didnt hear from " + (i.Value).(task).beacon.Name + "," + (i.Value).(task).beacon.Hostname)
-	}
-}
-
-func isin(heardfrom *list.List, beacon *clientpb.Beacon) bool {
-
-	return false
 }
 
-func runcommandonbeacon(rpc rpcpb.SliverRPCClient, command string, agent *clientpb.Beacon, args []string) task {
-
-	// sess, err := rpc.OpenSession(context.Background(), &sliverpb.OpenSession{
-	// 	Request: makeBeaconRequest(agent),
-	// 	C2S:     []string{},
-	// })
-	// print(sess)
-	// if err != nil {
-	// 	log.Print(err)
-	// 	return
-	// }
-	resp, err := rpc.Execute(context.Background(), &sliverpb.ExecuteReq{
-		Path:    command,
-		Output:  true,
-		Args:    args,
-		Request: makeBeaconRequest(agent),
-	})
-	if err != nil {
-		log.Print(err)
-		return task{"err", agent}
-
-	}
-
-	println("Beacon:" + agent.Hostname)
-	println("going to check back in with this beacon")
-	return task{resp.Response.TaskID, agent}
-
-}
-func runcommandon(rpc rpcpb.SliverRPCClient, command string, agent *clientpb.Session, args []string) {
-	resp, err := rpc.Execute(context.Background(), &sliverpb.ExecuteReq{
-		Path:    command,
-		Output:  true,
-		Request: makeRequest(agent),
-		Args:    args,
-	})
-	if err != nil {
-		log.Print(err)
-		return
-	}
-	println("Session:" + agent.Hostname)
-	println(string(resp.Stdout) + string(resp.Stderr))
-}
-func RunCommandOnNew(rpc rpcpb.SliverRPCClient, command string, args []string) {
-	// Open the event stream to be able to collect all events sent by  the server
-	eventStream, err := rpc.Events(context.Background(), &commonpb.Empty{})
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// infinite loop
-	for {
-		event, err := eventStream.Recv()
-		if err == io.EOF || event == nil {
-			return
-		}
-		// Trigger event based on type
-		switch event.EventType {
-
-		// a new session just came in
-		case consts.SessionOpenedEvent:
-			session := event.Session
-			// call any RPC you want, for the full list, see
-			// https://github.com/BishopFox/sliver/blob/master/protobuf/rpcpb/services.proto
-			runcommandon(rpc, command, session, args)
-			//beacon fields not extracted so cannot impliment
-			// case consts.BeaconRegisteredEvent:
-			// 	beacon := event.Data
-			// 	print(beacon)
-			// 	runcommandonbeacon(rpc, command, clientpb.Beacon(beacon))
-		}
+// sleep pauses for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
 	}
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bishopfox/sliver/protobuf/clientpb"
+)
+
+// counterMetrics are the counters Sliverer keeps while it runs, each split by
+// one label.
+var counterMetrics = []struct {
+	name  string
+	label string
+	help  string
+}{
+	{"sliverer_commands_total", "outcome", "Commands executed on implants, by outcome."},
+	{"sliverer_renames_total", "outcome", "Implant renames, by outcome."},
+	{"sliverer_pwnboard_posts_total", "status", "POSTs to pwnboard, by status code or error."},
+}
+
+// counters counts what this process has done, for the metrics endpoint.
+var counters = &counterSet{values: map[string]map[string]float64{}}
+
+type counterSet struct {
+	mu     sync.Mutex
+	values map[string]map[string]float64 // metric name -> label value -> count
+}
+
+func (c *counterSet) inc(name string, label string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values[name] == nil {
+		c.values[name] = map[string]float64{}
+	}
+	c.values[name][label]++
+}
+
+// outcomeLabel is "ok" for a nil err and "error" otherwise.
+func outcomeLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// metricFamily is one metric in the Prometheus text exposition format.
+type metricFamily struct {
+	name    string
+	typ     string // gauge or counter
+	help    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels []string // name, value, name, value, ...
+	value  float64
+}
+
+func (f *metricFamily) add(value float64, labels ...string) {
+	f.samples = append(f.samples, metricSample{labels: labels, value: value})
+}
+
+func (f *metricFamily) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+	for _, s := range f.samples {
+		pairs := []string{}
+		for i := 0; i+1 < len(s.labels); i += 2 {
+			pairs = append(pairs, s.labels[i]+`="`+escapeLabel(s.labels[i+1])+`"`)
+		}
+		labels := ""
+		if len(pairs) > 0 {
+			labels = "{" + strings.Join(pairs, ",") + "}"
+		}
+		fmt.Fprintf(w, "%s%s %s\n", f.name, labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+// countFamily is a gauge with one sample per distinct label value in counts.
+func countFamily(name string, help string, label string, counts map[string]int) *metricFamily {
+	f := &metricFamily{name: name, typ: "gauge", help: help}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f.add(float64(counts[key]), label, key)
+	}
+	return f
+}
+
+// fleetMetrics gathers gauges describing every implant on servers. Beacons
+// are counted as live until they miss late check-ins.
+func fleetMetrics(ctx context.Context, servers []*server, late int) ([]*metricFamily, error) {
+	targets, err := findTargets(servers, runOptions{})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	liveSessions, liveBeacons, dead := 0, 0, 0
+	byOS, byTransport, bySubnet := map[string]int{}, map[string]int{}, map[string]int{}
+	checkin := &metricFamily{name: "sliverer_implant_last_checkin_seconds", typ: "gauge", help: "Seconds since the implant last checked in."}
+	pending := &metricFamily{name: "sliverer_beacon_pending_tasks", typ: "gauge", help: "Tasks queued for the beacon that it has not picked up yet."}
+	for _, t := range targets {
+		labels := []string{"id", t.ID(), "name", t.Name(), "hostname", t.Hostname(), "kind", t.Kind(), "server", t.Server()}
+		checkin.add(now.Sub(t.LastCheckin()).Seconds(), labels...)
+		if !alive(t, now, late) {
+			dead++
+			continue
+		}
+		if t.Kind() == kindBeacon {
+			liveBeacons++
+			tasks, err := t.RPC().GetBeaconTasks(ctx, &clientpb.Beacon{ID: t.ID()})
+			if err != nil {
+				log.Printf("[!] Failed to get tasks of beacon %s: %s\n", t.Name(), err)
+			} else {
+				queued := 0
+				for _, task := range tasks.Tasks {
+					if task.State == "pending" {
+						queued++
+					}
+				}
+				pending.add(float64(queued), labels...)
+			}
+		} else {
+			liveSessions++
+		}
+		byOS[t.OS()]++
+		byTransport[t.Transport()]++
+		bySubnet[subnet(t)]++
+	}
+
+	live := &metricFamily{name: "sliverer_implants_live", typ: "gauge", help: "Live implants, by kind."}
+	live.add(float64(liveSessions), "kind", kindSession)
+	live.add(float64(liveBeacons), "kind", kindBeacon)
+	deadFamily := &metricFamily{name: "sliverer_implants_dead", typ: "gauge", help: "Implants that are dead or have stopped checking in."}
+	deadFamily.add(float64(dead))
+	return []*metricFamily{
+		live,
+		deadFamily,
+		countFamily("sliverer_implants_by_os", "Live implants, by operating system.", "os", byOS),
+		countFamily("sliverer_implants_by_transport", "Live implants, by transport.", "transport", byTransport),
+		countFamily("sliverer_implants_by_subnet", "Live implants, by the subnet of their remote address.", "subnet", bySubnet),
+		checkin,
+		pending,
+	}, nil
+}
+
+// counterFamilies snapshots the counters.
+func counterFamilies() []*metricFamily {
+	counters.mu.Lock()
+	defer counters.mu.Unlock()
+	families := []*metricFamily{}
+	for _, m := range counterMetrics {
+		f := &metricFamily{name: m.name, typ: "counter", help: m.help}
+		values := counters.values[m.name]
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			f.add(values[key], m.label, key)
+		}
+		families = append(families, f)
+	}
+	return families
+}
+
+// defaultMetricsAddr is where metrics are served without --metrics. The
+// metrics name every implant, so they are only served on localhost unless
+// another address is given explicitly.
+const defaultMetricsAddr = "127.0.0.1:9100"
+
+// metricsListenAddr is the address to listen on for addr, with a missing host
+// meaning localhost rather than every interface.
+func metricsListenAddr(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return addr
+}
+
+// ServeMetrics serves Prometheus metrics about the implants on servers and
+// what Sliverer has done to them on addr until ctx is done.
+func ServeMetrics(ctx context.Context, addr string, servers []*server, late int) {
+	addr = metricsListenAddr(addr)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fleet, err := fleetMetrics(r.Context(), servers, late)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, f := range append(fleet, counterFamilies()...) {
+			f.write(w)
+		}
+	})
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	log.Println("[*] Serving metrics on " + addr + "/metrics")
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("[!] Metrics server stopped: %s\n", err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxNameLen is the longest implant name the server accepts, and nameChars
+// the only names it accepts at all: letters and digits (see Rename in the
+// server's rpc-reconfig.go).
+const maxNameLen = 32
+
+var nameChars = regexp.MustCompile(`^[[:alnum:]]+$`)
+
+// defaultNameTemplate names implants after their address and hostname, with
+// the h keeping the two apart.
+const defaultNameTemplate = "{ip}h{hostname}"
+
+var namePlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+
+var namePlaceholders = []string{"ip", "hostname", "short_hostname", "os", "user", "team", "transport", "kind"}
+
+// nameTemplate renders implant names such as "{ip}h{hostname}" from an
+// implant and one of its addresses.
+type nameTemplate struct {
+	template  string
+	teamOctet int // which octet of {ip} is {team}, counting from 1
+}
+
+func parseNameTemplate(template string, teamOctet int) (nameTemplate, error) {
+	for _, match := range namePlaceholder.FindAllStringSubmatch(template, -1) {
+		if !isinarray(namePlaceholders, match[1]) {
+			return nameTemplate{}, fmt.Errorf("unknown placeholder {%s}, expected one of {%s}", match[1], strings.Join(namePlaceholders, "}, {"))
+		}
+	}
+	if text := namePlaceholder.ReplaceAllString(template, ""); text != "" && !nameChars.MatchString(text) {
+		return nameTemplate{}, fmt.Errorf("%q has characters other than letters and digits outside placeholders, the server refuses names with them", template)
+	}
+	if teamOctet < 1 || teamOctet > 4 {
+		return nameTemplate{}, fmt.Errorf("team octet %d is not between 1 and 4", teamOctet)
+	}
+	return nameTemplate{template: template, teamOctet: teamOctet}, nil
+}
+
+func (n nameTemplate) values(t Target, ipaddr string) map[string]string {
+	hostname := t.Hostname()
+	user := t.Username()
+	if i := strings.LastIndex(user, `\`); i >= 0 {
+		user = user[i+1:]
+	}
+	return map[string]string{
+		"ip":             nameIP(ipaddr),
+		"hostname":       nameSafe(hostname),
+		"short_hostname": nameSafe(strings.SplitN(hostname, ".", 2)[0]),
+		"os":             nameSafe(t.OS()),
+		"user":           nameSafe(user),
+		"team":           n.team(ipaddr),
+		"transport":      nameSafe(t.Transport()),
+		"kind":           nameSafe(t.Kind()),
+	}
+}
+
+// nameSafe drops every character of value the server refuses in names, as in
+// web01corp for web01.corp.
+func nameSafe(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return -1
+	}, value)
+}
+
+// maxNameIPv6Len is the longest IPv6 address written out in full in a name.
+const maxNameIPv6Len = 20
+
+// nameIP formats ipaddr for an implant name, which may only hold letters and
+// digits, with an x in place of every dot or colon: 10x0x0x1 for IPv4 and
+// zero-compressed 2001xdb8xx1 for IPv6. IPv6 addresses that would take more
+// than maxNameIPv6Len characters are written as a z and their last 64 bits,
+// as in z5678x8a2ex370x7334, which parseNameIP knows not to read back as an
+// address.
+func nameIP(ipaddr string) string {
+	addr, err := netip.ParseAddr(ipaddr)
+	if err != nil {
+		return nameSafe(ipaddr)
+	}
+	if !addr.Is6() || addr.Is4In6() {
+		return strings.ReplaceAll(addr.Unmap().String(), ".", "x")
+	}
+	full := strings.ReplaceAll(addr.WithZone("").String(), ":", "x")
+	if len(full) <= maxNameIPv6Len {
+		return full
+	}
+	b := addr.As16()
+	groups := make([]string, 4)
+	for i := range groups {
+		groups[i] = strconv.FormatUint(uint64(b[8+2*i])<<8|uint64(b[9+2*i]), 16)
+	}
+	return "z" + strings.Join(groups, "x")
+}
+
+var (
+	nameIPv4 = regexp.MustCompile(`\d{1,3}(?:x\d{1,3}){3}`)
+	nameIPv6 = regexp.MustCompile(`[0-9a-f]{0,4}(?:x[0-9a-f]{0,4}){2,7}`)
+)
+
+// parseNameIP finds the address a previous rename put in name, in the form
+// nameIP writes it. IPv6 is looked for first, as its last four groups can
+// look like IPv4. Anything right after a z is part of a shortened IPv6
+// address, which is not an address, and is skipped.
+func parseNameIP(name string) (netip.Addr, bool) {
+	shortened := func(span []int) bool {
+		return span[0] > 0 && name[span[0]-1] == 'z'
+	}
+	for _, span := range nameIPv6.FindAllStringIndex(name, -1) {
+		if shortened(span) {
+			continue
+		}
+		match := name[span[0]:span[1]]
+		if addr, err := netip.ParseAddr(strings.ReplaceAll(match, "x", ":")); err == nil && addr.Is6() {
+			return addr, true
+		}
+	}
+	for _, span := range nameIPv4.FindAllStringIndex(name, -1) {
+		if shortened(span) {
+			continue
+		}
+		match := name[span[0]:span[1]]
+		if addr, err := netip.ParseAddr(strings.ReplaceAll(match, "x", ".")); err == nil {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// team is the configured octet of an IPv4 address, or "" for anything else.
+func (n nameTemplate) team(ipaddr string) string {
+	addr, err := netip.ParseAddr(ipaddr)
+	if err != nil || !addr.Unmap().Is4() {
+		return ""
+	}
+	return strconv.Itoa(int(addr.Unmap().As4()[n.teamOctet-1]))
+}
+
+func (n nameTemplate) expand(values map[string]string) string {
+	return namePlaceholder.ReplaceAllStringFunc(n.template, func(placeholder string) string {
+		return values[placeholder[1:len(placeholder)-1]]
+	})
+}
+
+// Render names t after ipaddr. Characters the server refuses are dropped from
+// every value, see nameSafe and nameIP. Names longer than the server allows are
+// shortened by trimming the longest values other than {ip} and ending the
+// last trimmed value with a hash of the full name, so that implants whose
+// names only differ in the trimmed part still get different names. The
+// address is never cut, so Render fails if the address and the rest of the
+// template alone are too long.
+func (n nameTemplate) Render(t Target, ipaddr string) (string, error) {
+	values := n.values(t, ipaddr)
+	name := n.expand(values)
+	if !nameChars.MatchString(name) {
+		// Only an empty name gets here, parseNameTemplate and values keep
+		// out everything else.
+		return "", fmt.Errorf("%s renders an empty name for %s", n.template, t.Name())
+	}
+	if len(name) <= maxNameLen {
+		return name, nil
+	}
+	sum := sha1.Sum([]byte(name))
+	suffix := hex.EncodeToString(sum[:])[:4]
+
+	keys := []string{}
+	for _, match := range namePlaceholder.FindAllStringSubmatch(n.template, -1) {
+		if match[1] != "ip" && !isinarray(keys, match[1]) {
+			keys = append(keys, match[1])
+		}
+	}
+	sort.Strings(keys)
+	// shortened is the name with the hash ending the trimmed value, at every
+	// place that value appears.
+	trimmed := ""
+	shortened := func() string {
+		v := map[string]string{}
+		for key, value := range values {
+			v[key] = value
+		}
+		v[trimmed] += suffix
+		return n.expand(v)
+	}
+	for trimmed == "" || len(shortened()) > maxNameLen {
+		longest := ""
+		for _, key := range keys {
+			if longest == "" || len(values[key]) > len(values[longest]) {
+				longest = key
+			}
+		}
+		if longest == "" || values[longest] == "" {
+			// Nothing left to trim but the address and the template itself.
+			return "", fmt.Errorf("name %q is longer than %d characters even with everything but {ip} trimmed", name, maxNameLen)
+		}
+		values[longest] = values[longest][:len(values[longest])-1]
+		trimmed = longest
+	}
+	return shortened(), nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTarget is a Target with just the properties names and notifications
+// are made from. Unset fields fall back to a live linux beacon.
+type fakeTarget struct {
+	Target
+	id          string
+	name        string
+	hostname    string
+	os          string
+	kind        string
+	lastCheckin time.Time
+	interval    time.Duration
+	dead        bool
+}
+
+func (f fakeTarget) ID() string              { return f.id }
+func (f fakeTarget) Name() string            { return f.name }
+func (f fakeTarget) Hostname() string        { return f.hostname }
+func (f fakeTarget) Arch() string            { return "amd64" }
+func (f fakeTarget) Username() string        { return `CORP\admin` }
+func (f fakeTarget) Transport() string       { return "mtls" }
+func (f fakeTarget) RemoteAddress() string   { return "10.0.0.1:44321" }
+func (f fakeTarget) Server() string          { return "op@sliver:31337" }
+func (f fakeTarget) LastCheckin() time.Time  { return f.lastCheckin }
+func (f fakeTarget) NextCheckin() time.Time  { return time.Time{} }
+func (f fakeTarget) Interval() time.Duration { return f.interval }
+func (f fakeTarget) IsDead() bool            { return f.dead }
+
+func (f fakeTarget) OS() string {
+	if f.os == "" {
+		return "linux"
+	}
+	return f.os
+}
+
+func (f fakeTarget) Kind() string {
+	if f.kind == "" {
+		return kindBeacon
+	}
+	return f.kind
+}
+
+func TestRender(t *testing.T) {
+	long := "a-very-long-hostname.corp.example.com"
+	tests := []struct {
+		template string
+		hostname string
+		ip       string
+		want     string // exact name, or "" to only check the length and address
+		wantErr  bool
+	}{
+		{template: defaultNameTemplate, hostname: "web01.corp", ip: "10.0.0.1", want: "10x0x0x1hweb01corp"},
+		{template: "t{team}x{short_hostname}x{user}", hostname: "web01.corp", ip: "10.5.0.1", want: "t5xweb01xadmin"},
+		{template: "{ip}{hostname}", hostname: "web01", ip: "2001:db8::10", want: "2001xdb8xx10web01"},
+		{template: defaultNameTemplate, hostname: long, ip: "10.100.200.123"},
+		{template: "{hostname}at{ip}at{hostname}", hostname: long, ip: "10.0.0.1"},
+		{template: "{hostname}at{ip}at{hostname}on{os}", hostname: long, ip: "10.100.200.123"},
+		{template: "{hostname}at{ip}at{hostname}", hostname: long, ip: "2001:db8:85a3:1234:5678:8a2e:370:7334"},
+		{template: "{ip}thistemplateisfartoolong", hostname: "web01", ip: "10.100.200.123", wantErr: true},
+		{template: "{os}{hostname}", hostname: "...", ip: "10.0.0.1", want: "linux"},
+	}
+	for _, tt := range tests {
+		n, err := parseNameTemplate(tt.template, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, err := n.Render(fakeTarget{hostname: tt.hostname}, tt.ip)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s with %s: got %q, want an error", tt.template, tt.hostname, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s with %s: %s", tt.template, tt.hostname, err)
+			continue
+		}
+		if !nameChars.MatchString(name) {
+			t.Errorf("%s with %s: %q has characters the server refuses", tt.template, tt.hostname, name)
+		}
+		if len(name) > maxNameLen {
+			t.Errorf("%s with %s: %q is %d characters, over %d", tt.template, tt.hostname, name, len(name), maxNameLen)
+		}
+		if strings.Contains(tt.template, "{ip}") && !strings.Contains(name, nameIP(tt.ip)) {
+			t.Errorf("%s with %s: %q lost the address %s", tt.template, tt.hostname, name, tt.ip)
+		}
+		if tt.want != "" && name != tt.want {
+			t.Errorf("%s with %s: got %q, want %q", tt.template, tt.hostname, name, tt.want)
+		}
+	}
+}
+
+func TestParseNameTemplate(t *testing.T) {
+	for _, template := range []string{"{ip}_{hostname}.", "t{team}-{ip}", "{ip} {hostname}"} {
+		if _, err := parseNameTemplate(template, 2); err == nil {
+			t.Errorf("%s: got no error, want one for characters the server refuses", template)
+		}
+	}
+	if _, err := parseNameTemplate("{ip}", 2); err != nil {
+		t.Errorf("{ip}: %s", err)
+	}
+}
+
+func TestRenderEmpty(t *testing.T) {
+	n, err := parseNameTemplate("{hostname}", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := n.Render(fakeTarget{hostname: "..."}, "10.0.0.1"); err == nil {
+		t.Errorf("got %q, want an error for an empty name", name)
+	}
+}
+
+func TestRenderKeepsNamesApart(t *testing.T) {
+	n, err := parseNameTemplate(defaultNameTemplate, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := n.Render(fakeTarget{hostname: "a-very-long-hostname.corp.example.com"}, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := n.Render(fakeTarget{hostname: "a-very-long-hostname.corp.example.org"}, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Errorf("hostnames differing past the cut both became %q", a)
+	}
+}
+
+func TestNameIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		want     string
+		readBack bool // whether parseNameIP recovers ip from the name
+	}{
+		{ip: "10.0.0.1", want: "10x0x0x1", readBack: true},
+		{ip: "2001:db8::10", want: "2001xdb8xx10", readBack: true},
+		{ip: "fd00:1:2::5", want: "fd00x1x2xx5", readBack: true},
+		{ip: "2001:db8:85a3:1234:5678:8a2e:370:7334", want: "z5678x8a2ex370x7334"},
+		{ip: "2001:db8:85a3:1234:0:1:2:3", want: "z0x1x2x3"},
+	}
+	for _, tt := range tests {
+		got := nameIP(tt.ip)
+		if got != tt.want {
+			t.Errorf("nameIP(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+		name := got + "hweb01"
+		addr, ok := parseNameIP(name)
+		if ok != tt.readBack || (ok && addr.String() != tt.ip) {
+			t.Errorf("parseNameIP(%q) = %s, %t, want %s, %t", name, addr, ok, tt.ip, tt.readBack)
+		}
+	}
+}
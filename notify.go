@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	consts "github.com/bishopfox/sliver/client/constants"
+)
+
+// notifyEvents are the events a chat channel can be told about.
+var notifyEvents = []string{"session", "beacon", "closed", "missed", "command"}
+
+// notifier posts implant lifecycle events and command summaries to chat
+// channels. It is nil when notifications are off.
+var notifier *chatNotifier
+
+// chatNotifier is loaded from a JSON file of incoming webhooks:
+//
+//	{"missed_checkins": 3, "channels": [
+//	  {"platform": "slack", "url": "https://hooks.slack.com/...", "events": ["session", "closed"], "select": "os=windows", "per_minute": 10}
+//	]}
+//
+// platform is slack, mattermost or discord. events defaults to every event in
+// notifyEvents, select is a selector implants must match and per_minute caps
+// how many messages the channel gets, 30 by default.
+type chatNotifier struct {
+	Missed   int            `json:"missed_checkins"` // check-ins a beacon misses before it is reported
+	Channels []*chatChannel `json:"channels"`
+
+	client  *http.Client
+	mu      sync.Mutex
+	missed  map[string]bool // IDs of beacons reported as missing
+	closed  bool            // set by Close, nothing is queued after it
+	posting sync.WaitGroup  // one deliver per channel
+}
+
+// notifyQueueLen is how many messages a channel holds while its webhook is
+// slow. Messages beyond that are dropped and counted like rate limited ones.
+const notifyQueueLen = 100
+
+type chatChannel struct {
+	Platform  string   `json:"platform"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Select    string   `json:"select"`
+	PerMinute int      `json:"per_minute"`
+
+	selector   selector
+	mu         sync.Mutex
+	sent       []time.Time // within the last minute
+	suppressed int
+	queue      chan string // messages waiting to be posted
+}
+
+func loadNotifier(file string) (*chatNotifier, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	n := &chatNotifier{Missed: 3}
+	if err := json.Unmarshal(data, n); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for _, c := range n.Channels {
+		switch c.Platform {
+		case "slack", "mattermost", "discord":
+		default:
+			return nil, fmt.Errorf("%s: unknown platform %q, expected slack, mattermost or discord", file, c.Platform)
+		}
+		if c.URL == "" {
+			return nil, fmt.Errorf("%s: %s channel has no url", file, c.Platform)
+		}
+		if len(c.Events) == 0 {
+			c.Events = notifyEvents
+		}
+		for _, event := range c.Events {
+			if !isinarray(notifyEvents, event) {
+				return nil, fmt.Errorf("%s: unknown event %q, expected one of %s", file, event, strings.Join(notifyEvents, ", "))
+			}
+		}
+		if c.selector, err = parseSelector(c.Select); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if c.PerMinute <= 0 {
+			c.PerMinute = 30
+		}
+		c.queue = make(chan string, notifyQueueLen)
+	}
+	n.client = &http.Client{Timeout: 10 * time.Second}
+	n.missed = map[string]bool{}
+	for _, c := range n.Channels {
+		n.posting.Add(1)
+		go n.deliver(c)
+	}
+	return n, nil
+}
+
+// Close posts the messages still queued and stops notifying.
+func (n *chatNotifier) Close() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		for _, c := range n.Channels {
+			close(c.queue)
+		}
+	}
+	n.mu.Unlock()
+	n.posting.Wait()
+}
+
+// Implant tells every channel that wants event about t.
+func (n *chatNotifier) Implant(event string, t Target) {
+	if n == nil {
+		return
+	}
+	var text string
+	switch event {
+	case "session":
+		text = "New session " + describeImplant(t)
+	case "beacon":
+		text = fmt.Sprintf("New beacon %s, checking in every %s", describeImplant(t), t.Interval())
+	case "closed":
+		text = "Lost session " + describeImplant(t)
+	case "missed":
+		text = fmt.Sprintf("Beacon %s missed %d check-ins, last seen %s ago", describeImplant(t), n.Missed, time.Since(t.LastCheckin()).Round(time.Second))
+	}
+	n.send(event, t, text)
+}
+
+// Command tells every channel that wants command summaries how a command run
+// went.
+func (n *chatNotifier) Command(command string, args []string, ok int, failed int) {
+	if n == nil {
+		return
+	}
+	text := fmt.Sprintf("Ran %s on %d implants: %d ok, %d failed", quoteArgs(append([]string{command}, args...)), ok+failed, ok, failed)
+	n.send("command", nil, text)
+}
+
+func describeImplant(t Target) string {
+	return fmt.Sprintf("%s on %s (%s, %s/%s, %s) via %s on %s", t.Name(), t.Hostname(), remoteIP(t), t.OS(), t.Arch(), t.Username(), t.Transport(), t.Server())
+}
+
+// send queues text for every channel that wants event and, unless t is nil,
+// whose selector matches t. It never waits for a webhook, so a slow one does
+// not hold up the event stream it is called from.
+func (n *chatNotifier) send(event string, t Target, text string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	for _, c := range n.Channels {
+		if !isinarray(c.Events, event) || (t != nil && !c.selector.Match(t)) {
+			continue
+		}
+		if !c.allow(time.Now()) {
+			continue
+		}
+		select {
+		case c.queue <- c.withSuppressed(text):
+		default:
+			c.mu.Lock()
+			c.suppressed++
+			c.mu.Unlock()
+		}
+	}
+}
+
+// deliver posts the messages queued for c, one at a time, until it is closed.
+func (n *chatNotifier) deliver(c *chatChannel) {
+	defer n.posting.Done()
+	for text := range c.queue {
+		if err := n.post(c, text); err != nil {
+			log.Printf("[!] Failed to notify %s: %s\n", c.Platform, err)
+		}
+	}
+}
+
+// allow reports whether the channel may be sent another message now,
+// counting the messages it may not.
+func (c *chatChannel) allow(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	recent := c.sent[:0]
+	for _, sent := range c.sent {
+		if now.Sub(sent) < time.Minute {
+			recent = append(recent, sent)
+		}
+	}
+	c.sent = recent
+	if len(c.sent) >= c.PerMinute {
+		c.suppressed++
+		return false
+	}
+	c.sent = append(c.sent, now)
+	return true
+}
+
+// withSuppressed adds how many messages were dropped since the last one.
+func (c *chatChannel) withSuppressed(text string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.suppressed > 0 {
+		text += fmt.Sprintf(" (%d earlier notifications were rate limited)", c.suppressed)
+		c.suppressed = 0
+	}
+	return text
+}
+
+// post sends text in the channel's incoming webhook format.
+func (n *chatNotifier) post(c *chatChannel, text string) error {
+	var payload map[string]string
+	switch c.Platform {
+	case "discord":
+		// Discord refuses messages over 2000 characters.
+		if utf8.RuneCountInString(text) > 2000 {
+			text = string([]rune(text)[:2000])
+		}
+		payload = map[string]string{"content": text, "username": "Sliverer"}
+	case "mattermost":
+		payload = map[string]string{"text": text, "username": "Sliverer"}
+	default:
+		payload = map[string]string{"text": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(c.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// RunNotifier notifies about implants opening, registering and closing on
+// every server, and about beacons missing check-ins, until ctx is done.
+func RunNotifier(ctx context.Context, servers []*server) {
+	if notifier == nil {
+		return
+	}
+	go notifier.watchMissed(ctx, servers)
+	forEachServer(ctx, servers, func(ctx context.Context, srv *server) {
+		watchImplants(ctx, srv, nil, func(eventType string, t Target) {
+			switch eventType {
+			case consts.SessionOpenedEvent:
+				notifier.Implant("session", t)
+			case consts.BeaconRegisteredEvent:
+				notifier.Implant("beacon", t)
+			case consts.SessionClosedEvent:
+				notifier.Implant("closed", t)
+			}
+		})
+	})
+}
+
+// watchMissed checks every minute for beacons that have missed n.Missed
+// check-ins and notifies about each once, until it checks in again. Beacons
+// already missing when it starts are not reported.
+func (n *chatNotifier) watchMissed(ctx context.Context, servers []*server) {
+	first := true
+	for {
+		targets, err := findTargets(servers, runOptions{})
+		if err != nil {
+			log.Print(err)
+		} else {
+			n.checkMissed(targets, time.Now(), !first)
+			first = false
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute):
+		}
+	}
+}
+
+// checkMissed notifies about every beacon in targets that has newly missed
+// n.Missed check-ins, if notify is set, and remembers which are missing.
+func (n *chatNotifier) checkMissed(targets []Target, now time.Time, notify bool) {
+	for _, t := range targets {
+		if t.Kind() != kindBeacon {
+			continue
+		}
+		n.mu.Lock()
+		missing := !alive(t, now, n.Missed)
+		reported := n.missed[t.ID()]
+		n.missed[t.ID()] = missing
+		n.mu.Unlock()
+		if missing && !reported && notify {
+			n.Implant("missed", t)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// chatStandIn is a local stand-in for the incoming webhooks of every
+// platform, recording what was posted to each path.
+type chatStandIn struct {
+	*httptest.Server
+	mu    sync.Mutex
+	posts map[string][]map[string]string // by path
+	hold  chan struct{}                  // if set, requests wait until it is closed
+}
+
+func newChatStandIn(t *testing.T) *chatStandIn {
+	s := &chatStandIn{posts: map[string][]map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.hold != nil {
+			<-s.hold
+		}
+		payload := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("%s: %s", r.URL.Path, err)
+		}
+		s.mu.Lock()
+		s.posts[r.URL.Path] = append(s.posts[r.URL.Path], payload)
+		s.mu.Unlock()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *chatStandIn) received(path string) []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.posts[path]
+}
+
+// loadTestNotifier loads a notifier from config, with {url} replaced by the
+// stand-in's URL. Its messages are posted by the time the test calls Close.
+func loadTestNotifier(t *testing.T, s *chatStandIn, config string) *chatNotifier {
+	file := filepath.Join(t.TempDir(), "notify.json")
+	if err := os.WriteFile(file, []byte(strings.ReplaceAll(config, "{url}", s.URL)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	n, err := loadNotifier(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestNotifyPayloads(t *testing.T) {
+	s := newChatStandIn(t)
+	n := loadTestNotifier(t, s, `{"channels": [
+		{"platform": "slack", "url": "{url}/slack"},
+		{"platform": "mattermost", "url": "{url}/mattermost"},
+		{"platform": "discord", "url": "{url}/discord"}
+	]}`)
+	n.Command("whoami", nil, 3, 1)
+	n.Close()
+
+	want := map[string]map[string]string{
+		"/slack":      {"text": ""},
+		"/mattermost": {"text": "", "username": "Sliverer"},
+		"/discord":    {"content": "", "username": "Sliverer"},
+	}
+	for path, keys := range want {
+		posts := s.received(path)
+		if len(posts) != 1 {
+			t.Errorf("%s got %d posts, want 1", path, len(posts))
+			continue
+		}
+		if len(posts[0]) != len(keys) {
+			t.Errorf("%s got %v, want the keys of %v", path, posts[0], keys)
+		}
+		for key := range keys {
+			if _, ok := posts[0][key]; !ok {
+				t.Errorf("%s got %v, missing %q", path, posts[0], key)
+			}
+		}
+		text := posts[0]["text"] + posts[0]["content"]
+		if !strings.Contains(text, `"whoami" on 4 implants: 3 ok, 1 failed`) {
+			t.Errorf("%s got message %q", path, text)
+		}
+	}
+}
+
+func TestNotifyFilters(t *testing.T) {
+	s := newChatStandIn(t)
+	n := loadTestNotifier(t, s, `{"channels": [
+		{"platform": "slack", "url": "{url}/windows", "events": ["session"], "select": "os=windows"},
+		{"platform": "slack", "url": "{url}/all"}
+	]}`)
+	windows := fakeTarget{name: "w1", hostname: "dc01", os: "windows", kind: kindSession}
+	linux := fakeTarget{name: "l1", hostname: "web01", kind: kindSession}
+	n.Implant("session", windows)
+	n.Implant("session", linux)
+	n.Implant("closed", windows)
+	n.Close()
+
+	if got := len(s.received("/windows")); got != 1 {
+		t.Errorf("filtered channel got %d posts, want only the windows session", got)
+	} else if text := s.received("/windows")[0]["text"]; !strings.Contains(text, "New session w1 on dc01") {
+		t.Errorf("filtered channel got %q", text)
+	}
+	if got := len(s.received("/all")); got != 3 {
+		t.Errorf("unfiltered channel got %d posts, want 3", got)
+	}
+}
+
+func TestNotifyRateLimit(t *testing.T) {
+	s := newChatStandIn(t)
+	n := loadTestNotifier(t, s, `{"channels": [{"platform": "slack", "url": "{url}/slack", "per_minute": 2}]}`)
+	for i := 0; i < 5; i++ {
+		n.Command("whoami", nil, 1, 0)
+	}
+
+	// Let the minute pass.
+	c := n.Channels[0]
+	c.mu.Lock()
+	for i := range c.sent {
+		c.sent[i] = c.sent[i].Add(-time.Minute)
+	}
+	c.mu.Unlock()
+	n.Command("whoami", nil, 1, 0)
+	n.Command("whoami", nil, 1, 0)
+	n.Close()
+
+	posts := s.received("/slack")
+	if len(posts) != 4 {
+		t.Fatalf("got %d posts, want 2 within the first minute and 2 after it", len(posts))
+	}
+	if text := posts[1]["text"]; strings.Contains(text, "rate limited") {
+		t.Errorf("got %q before anything was rate limited", text)
+	}
+	if text := posts[2]["text"]; !strings.HasSuffix(text, "(3 earlier notifications were rate limited)") {
+		t.Errorf("got %q, want the suppressed count", text)
+	}
+	if text := posts[3]["text"]; strings.Contains(text, "rate limited") {
+		t.Errorf("suppressed count repeated in %q", text)
+	}
+}
+
+func TestNotifyDoesNotWait(t *testing.T) {
+	s := newChatStandIn(t)
+	s.hold = make(chan struct{})
+	n := loadTestNotifier(t, s, `{"channels": [{"platform": "discord", "url": "{url}/discord"}]}`)
+	done := make(chan struct{})
+	go func() {
+		n.Command("whoami", nil, 1, 0)
+		n.Command(strings.Repeat("é", 3000), nil, 1, 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(s.hold)
+		t.Fatal("sending waited for the webhook to answer")
+	}
+	close(s.hold)
+	n.Close()
+
+	posts := s.received("/discord")
+	if len(posts) != 2 {
+		t.Fatalf("got %d posts, want 2", len(posts))
+	}
+	if text := posts[1]["content"]; utf8.RuneCountInString(text) != 2000 || !utf8.ValidString(text) {
+		t.Errorf("got %d characters, valid UTF-8 %t, want 2000 valid characters", utf8.RuneCountInString(text), utf8.ValidString(text))
+	}
+}
+
+func TestNotifyMissedOnce(t *testing.T) {
+	s := newChatStandIn(t)
+	n := loadTestNotifier(t, s, `{"missed_checkins": 3, "channels": [{"platform": "slack", "url": "{url}/slack", "events": ["missed"]}]}`)
+	now := time.Now()
+	beacon := fakeTarget{id: "b1", name: "b1", hostname: "web01", interval: time.Minute, lastCheckin: now.Add(-time.Minute)}
+	alreadyLost := fakeTarget{id: "b2", name: "b2", hostname: "web02", interval: time.Minute, lastCheckin: now.Add(-time.Hour)}
+
+	// Beacons already missing at start are not reported, and one that goes
+	// missing is reported once.
+	n.checkMissed([]Target{beacon, alreadyLost}, now, false)
+	beacon.lastCheckin = now.Add(-5 * time.Minute)
+	n.checkMissed([]Target{beacon, alreadyLost}, now, true)
+	n.checkMissed([]Target{beacon, alreadyLost}, now.Add(time.Minute), true)
+
+	// Once it checks in again it can be reported again.
+	beacon.lastCheckin = now
+	n.checkMissed([]Target{beacon}, now, true)
+	beacon.lastCheckin = now.Add(-5 * time.Minute)
+	n.checkMissed([]Target{beacon}, now, true)
+	n.Close()
+
+	posts := s.received("/slack")
+	if len(posts) != 2 {
+		t.Fatalf("got %d notices, want one each time the beacon went missing", len(posts))
+	}
+	for _, post := range posts {
+		if text := post["text"]; !strings.Contains(text, "Beacon b1 on web01") || !strings.Contains(text, "missed 3 check-ins") {
+			t.Errorf("got %q", text)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// alive reports whether t looks alive from what the server knows of it,
+// without tasking it. Sessions are alive until the server marks them dead.
+// Beacons are given up on once they have missed their next check-in and late
+// intervals have passed since their last one.
+func alive(t Target, now time.Time, late int) bool {
+	if t.IsDead() {
+		return false
+	}
+	if t.Kind() != kindBeacon || t.Interval() <= 0 {
+		return true
+	}
+	if next := t.NextCheckin(); next.Unix() > 0 && now.Before(next) {
+		return true
+	}
+	return now.Sub(t.LastCheckin()) <= time.Duration(late)*t.Interval()
+}
+
+// knownIP is the address t is reported as without tasking it: the address a
+// previous rename put in its name, else the address it connects from. IPv6
+// addresses that rename had to shorten are not used. It reports false if
+// neither passes the address filter.
+func knownIP(opts runOptions, t Target) (string, bool) {
+	if addr, ok := parseNameIP(t.Name()); ok && opts.filter.Keep("", addr) {
+		return addr.String(), true
+	}
+	if addr, ok := remoteAddr(t); ok && opts.filter.Keep("", addr) {
+		return addr.String(), true
+	}
+	return "", false
+}
+
+// SendToPwnBoardNoTouch reports every live implant to pwnboard using only
+// what the server already knows about it, so nothing is queued on beacons and
+// hundreds of implants are reported at once.
+func SendToPwnBoardNoTouch(servers []*server, opts runOptions, report Reporter) {
+	targets, err := findTargets(servers, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	now := time.Now()
+	reported, stale := 0, 0
+	for _, t := range targets {
+		if !alive(t, now, opts.late) {
+			stale++
+			continue
+		}
+		ipaddr, ok := knownIP(opts, t)
+		if !ok {
+			log.Printf("[!] No usable address for %s\n", describeTarget(t))
+			continue
+		}
+		if !opts.scope.Permit(t, ipaddr) {
+			continue
+		}
+		reported++
+		if opts.dryRun {
+			fmt.Println(describeTarget(t))
+			fmt.Println("    report " + ipaddr + " to " + report.String())
+			continue
+		}
+		println(t.Name() + "," + t.Hostname())
+		println(ipaddr)
+		report.Report(t, ipaddr)
+	}
+	verb := "reported"
+	if opts.dryRun {
+		verb = "would be reported"
+	}
+	fmt.Printf("%d implants %s, %d dead or overdue skipped\n", reported, verb, stale)
+}
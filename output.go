@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bishopfox/sliver/protobuf/sliverpb"
+)
+
+var outputFormats = []string{"text", "json", "ndjson"}
+
+// commandRecord is the machine readable result of running a command on one
+// implant.
+type commandRecord struct {
+	Server   string    `json:"server"`
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Hostname string    `json:"hostname"`
+	Kind     string    `json:"kind"`
+	OS       string    `json:"os"`
+	Stdout   string    `json:"stdout"`
+	Stderr   string    `json:"stderr"`
+	ExitCode *uint32   `json:"exit_code"` // null if the command never ran
+	TaskID   string    `json:"task_id,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Seconds  float64   `json:"duration_seconds"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func newCommandRecord(o outcome) commandRecord {
+	t := o.target
+	rec := commandRecord{
+		Server:   t.Server(),
+		ID:       t.ID(),
+		Name:     t.Name(),
+		Hostname: t.Hostname(),
+		Kind:     t.Kind(),
+		OS:       t.OS(),
+		TaskID:   o.taskID,
+		Started:  o.started,
+		Finished: o.finished,
+		Seconds:  o.finished.Sub(o.started).Seconds(),
+	}
+	if o.err != nil {
+		rec.Error = o.err.Error()
+	}
+	if exec, ok := o.reply.(*sliverpb.Execute); ok && o.err == nil {
+		rec.Stdout = string(exec.Stdout)
+		rec.Stderr = string(exec.Stderr)
+		rec.ExitCode = &exec.Status
+	}
+	return rec
+}
+
+// resultWriter prints command results as they come in.
+type resultWriter interface {
+	Write(o outcome)
+	// Close flushes anything held back until every result is in.
+	Close() error
+}
+
+func newResultWriter(format string) resultWriter {
+	switch format {
+	case "json":
+		return &jsonWriter{w: os.Stdout}
+	case "ndjson":
+		return &ndjsonWriter{enc: json.NewEncoder(os.Stdout)}
+	}
+	return textWriter{}
+}
+
+// textWriter prints the human readable banner and output of every host.
+type textWriter struct{}
+
+func (textWriter) Write(o outcome) {
+	if o.err != nil {
+		log.Print(o.err)
+		return
+	}
+	t := o.target
+	exec := o.reply.(*sliverpb.Execute)
+	if t.Kind() == kindBeacon {
+		println("Beacon:" + t.Name() + "," + t.Hostname() + " [" + t.Server() + "]")
+	} else {
+		println("Session:" + t.Hostname() + " [" + t.Server() + "]")
+	}
+	println(string(exec.Stdout) + string(exec.Stderr))
+}
+
+func (textWriter) Close() error { return nil }
+
+// ndjsonWriter prints one JSON record per line as soon as each result is in.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(o outcome) {
+	if err := n.enc.Encode(newCommandRecord(o)); err != nil {
+		log.Print(err)
+	}
+}
+
+func (n *ndjsonWriter) Close() error { return nil }
+
+// jsonWriter prints a single JSON array once every result is in.
+type jsonWriter struct {
+	w       io.Writer
+	records []commandRecord
+}
+
+func (j *jsonWriter) Write(o outcome) {
+	j.records = append(j.records, newCommandRecord(o))
+}
+
+func (j *jsonWriter) Close() error {
+	if j.records == nil {
+		j.records = []commandRecord{}
+	}
+	enc := json.NewEncoder(j.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(j.records)
+}
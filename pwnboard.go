@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PwnBoard struct {
+	IPs  string `json:"ip"`
+	Type string `json:"type"`
+	// AccessType tells beacon callbacks from interactive sessions.
+	AccessType string `json:"access_type"`
+	Hostname   string `json:"hostname,omitempty"`
+	Implant    string `json:"implant,omitempty"`
+	Operator   string `json:"operator,omitempty"`
+	Message    string `json:"message,omitempty"`
+	// Timestamp is when a spooled update was observed, in unix seconds. It
+	// is only sent when the spool is flushed.
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
+// defaultPwnboardPath is pwnboard's endpoint for reporting access to a host.
+const defaultPwnboardPath = "/pwn/boxaccess"
+
+// pwnboardFields are the optional payload fields --pwnboard-fields picks from.
+var pwnboardFields = []string{"hostname", "implant", "operator"}
+
+// URLs splits the ^ separated --url value into the endpoints updates are
+// posted to.
+func (c *pwnboardClient) URLs(urls string) []string {
+	// Default URL if none is provided
+	if urls == "" {
+		urls = "http://127.0.0.1"
+	}
+	// Split the urls string into a slice of URLs
+	urlList := []string{}
+	for _, url := range strings.Split(urls, "^") {
+		// Append the endpoint to each URL
+		urlList = append(urlList, strings.TrimSuffix(url, "/")+c.path)
+	}
+	return urlList
+}
+
+// updatepwnBoard posts ip to every pwnboard in urls and returns the last
+// error, if any. Failed updates are spooled for a later flush.
+func updatepwnBoard(t Target, ip string, urls string) error {
+	var lastErr error
+	for _, finalUrl := range pwnboard.URLs(urls) {
+		// Create the struct
+		data := pwnboard.payload(t, ip)
+
+		// Marshal the data
+		sendit, err := json.Marshal(data)
+		if err != nil {
+			fmt.Println("\n[-] ERROR SENDING POST:", err)
+			audit.Action(t, auditEntry{Action: "pwnboard", URL: finalUrl, IP: ip}, err)
+			lastErr = err
+			continue // Skip this iteration and proceed with the next URL
+		}
+
+		// Send the post to pwnboard
+		status, err := pwnboard.Post(finalUrl, sendit)
+		if status == 0 {
+			fmt.Println("[-] ERROR SENDING POST:", err)
+		} else {
+			fmt.Println("POST sent to:", finalUrl, "Status Code:", status)
+		}
+		audit.Action(t, auditEntry{Action: "pwnboard", URL: finalUrl, IP: ip}, err)
+		if err != nil {
+			// Updates pwnboard refused will be refused again.
+			if transient(status) {
+				pwnboard.spool.add(finalUrl, data, time.Now())
+			}
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// pwnboard posts updates to pwnboard. main sets it up from the --pwnboard-*
+// flags before anything is reported.
+var pwnboard *pwnboardClient
+
+// pwnboardConfig is how pwnboard is reached.
+type pwnboardConfig struct {
+	timeout  time.Duration // per attempt
+	retries  int
+	ca       string // PEM file of extra CAs to trust
+	insecure bool
+	token    string // sent as a bearer token
+	header   string // extra "Name: value" header
+	proxy    string
+	spool    string // file failed updates are kept in
+	path     string // endpoint appended to every URL
+	types    string // ^ separated type names, optionally per transport glob
+	fields   string // ^ separated optional fields to send
+	message  string
+}
+
+// pwnboardClient posts to pwnboard, retrying network errors and 5xx
+// responses with exponential backoff, and keeps count of how every URL fared
+// for Summary.
+type pwnboardClient struct {
+	client  *http.Client
+	retries int
+	backoff time.Duration // before the first retry, doubled after each
+	header  http.Header
+	spool   *pwnboardSpool // nil if spooling is off
+	path    string
+	types   []pwnboardType
+	fields  []string
+	message string
+
+	mu      sync.Mutex
+	results map[string]*pwnboardResult // by URL
+}
+
+type pwnboardResult struct {
+	ok      int
+	failed  int
+	lastErr string
+}
+
+func newPwnboardClient(cfg pwnboardConfig) (*pwnboardClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.proxy != "" {
+		proxy, err := url.Parse(cfg.proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if cfg.ca != "" || cfg.insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: cfg.insecure}
+	}
+	if cfg.ca != "" {
+		pem, err := os.ReadFile(cfg.ca)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in " + cfg.ca)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	if cfg.token != "" {
+		header.Set("Authorization", "Bearer "+cfg.token)
+	}
+	if cfg.header != "" {
+		name, value, ok := strings.Cut(cfg.header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("header %q is not \"Name: value\"", cfg.header)
+		}
+		header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	c := &pwnboardClient{
+		client:  &http.Client{Transport: transport, Timeout: cfg.timeout},
+		retries: cfg.retries,
+		backoff: time.Second,
+		header:  header,
+		results: map[string]*pwnboardResult{},
+		path:    "/" + strings.TrimPrefix(cfg.path, "/"),
+		message: cfg.message,
+	}
+	for _, field := range splitList(cfg.fields) {
+		if !isinarray(pwnboardFields, field) {
+			return nil, fmt.Errorf("unknown pwnboard field %q, expected one of %s", field, strings.Join(pwnboardFields, ", "))
+		}
+		c.fields = append(c.fields, field)
+	}
+	var err error
+	if c.types, err = parsePwnboardTypes(cfg.types); err != nil {
+		return nil, err
+	}
+	if cfg.spool != "" {
+		if c.spool, err = loadPwnboardSpool(cfg.spool); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// pwnboardType is the type name reported for implants whose transport
+// matches a glob.
+type pwnboardType struct {
+	transport string
+	name      string
+}
+
+// parsePwnboardTypes parses ^ separated type names such as
+// "dns=sliver-dns^http*=sliver-web^sliver-{kind}". Names without a transport
+// glob apply to every transport, and the first matching name is used. Names
+// can include {kind} and {transport}.
+func parsePwnboardTypes(types string) ([]pwnboardType, error) {
+	parsed := []pwnboardType{}
+	for _, entry := range splitList(types) {
+		transport, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			transport, name = "*", entry
+		}
+		transport = strings.ToLower(transport)
+		if _, err := path.Match(transport, ""); err != nil {
+			return nil, fmt.Errorf("bad pwnboard type %q: %w", entry, err)
+		}
+		parsed = append(parsed, pwnboardType{transport: transport, name: name})
+	}
+	return parsed, nil
+}
+
+// payload is the update reporting access to t at ip.
+func (c *pwnboardClient) payload(t Target, ip string) PwnBoard {
+	data := PwnBoard{IPs: ip, Type: "sliver", AccessType: t.Kind(), Message: c.message}
+	for _, typ := range c.types {
+		if ok, _ := path.Match(typ.transport, strings.ToLower(t.Transport())); ok {
+			data.Type = strings.NewReplacer("{kind}", t.Kind(), "{transport}", t.Transport()).Replace(typ.name)
+			break
+		}
+	}
+	for _, field := range c.fields {
+		switch field {
+		case "hostname":
+			data.Hostname = t.Hostname()
+		case "implant":
+			data.Implant = t.Name()
+		case "operator":
+			data.Operator = t.Operator()
+		}
+	}
+	return data
+}
+
+// Post sends body to url, retrying network errors and 5xx responses. It
+// returns the last status code received, 0 if none was, and an error unless
+// the POST succeeded.
+func (c *pwnboardClient) Post(url string, body []byte) (int, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		status, err := c.post(url, body)
+		if err == nil && status/100 != 2 {
+			err = fmt.Errorf("status code %d", status)
+		}
+		retry := err != nil && transient(status)
+		if !retry || attempt >= c.retries {
+			c.record(url, err)
+			return status, err
+		}
+		log.Printf("[!] POST to %s failed: %s, retrying in %s\n", url, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// transient reports whether a failed POST that got status, 0 if it got no
+// response at all, may succeed if tried again later.
+func transient(status int) bool {
+	return status == 0 || status >= 500
+}
+
+func (c *pwnboardClient) post(url string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header = c.header.Clone()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		counters.inc("sliverer_pwnboard_posts_total", "error")
+		return 0, err
+	}
+	counters.inc("sliverer_pwnboard_posts_total", strconv.Itoa(resp.StatusCode))
+	defer resp.Body.Close()
+	// Drain the body so the connection can be reused.
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func (c *pwnboardClient) record(url string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result, ok := c.results[url]
+	if !ok {
+		result = &pwnboardResult{}
+		c.results[url] = result
+	}
+	if err != nil {
+		result.failed++
+		result.lastErr = err.Error()
+	} else {
+		result.ok++
+	}
+}
+
+// Summary prints how many POSTs to every URL succeeded and failed.
+func (c *pwnboardClient) Summary() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.results) == 0 {
+		return
+	}
+	urls := make([]string, 0, len(c.results))
+	for url := range c.results {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	log.Println("[*] pwnboard:")
+	for _, url := range urls {
+		result := c.results[url]
+		if result.failed == 0 {
+			log.Printf("[*]   %s: %d ok\n", url, result.ok)
+			continue
+		}
+		log.Printf("[!]   %s: %d ok, %d failed, last error: %s\n", url, result.ok, result.failed, result.lastErr)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Reporter is somewhere access to a host is reported, such as pwnboard.
+type Reporter interface {
+	// Report records that t is reachable and has the address ip.
+	Report(t Target, ip string) error
+	// String describes where reports go.
+	String() string
+	Close() error
+}
+
+// accessReport is what the webhook, file and stdout reporters are told about
+// access to a host.
+type accessReport struct {
+	Time      time.Time `json:"time"`
+	IP        string    `json:"ip"`
+	Hostname  string    `json:"hostname"`
+	Implant   string    `json:"implant"`
+	ImplantID string    `json:"implant_id"`
+	Kind      string    `json:"kind"`
+	OS        string    `json:"os"`
+	Transport string    `json:"transport"`
+	Server    string    `json:"server"`
+	Operator  string    `json:"operator"`
+}
+
+func newAccessReport(t Target, ip string) accessReport {
+	return accessReport{
+		Time:      time.Now(),
+		IP:        ip,
+		Hostname:  t.Hostname(),
+		Implant:   t.Name(),
+		ImplantID: t.ID(),
+		Kind:      t.Kind(),
+		OS:        t.OS(),
+		Transport: t.Transport(),
+		Server:    t.Server(),
+		Operator:  t.Operator(),
+	}
+}
+
+var csvHeader = []string{"time", "ip", "hostname", "implant", "implant_id", "kind", "os", "transport", "server", "operator"}
+
+func (r accessReport) csvRecord() []string {
+	return []string{r.Time.Format(time.RFC3339), r.IP, r.Hostname, r.Implant, r.ImplantID, r.Kind, r.OS, r.Transport, r.Server, r.Operator}
+}
+
+// parseReporters builds the reporters named in the ^ separated --report
+// value:
+//
+//	pwnboard          post to the --url pwnboards
+//	webhook=URL       post the webhook template filled in with an accessReport
+//	file=PATH         append to a CSV file, or JSONL if PATH ends in .jsonl
+//	stdout            print one line per report
+func parseReporters(spec string, urls string, webhookTemplate string) (multiReporter, error) {
+	reporters := multiReporter{}
+	for _, entry := range splitList(spec) {
+		kind, value, _ := strings.Cut(entry, "=")
+		var r Reporter
+		var err error
+		switch kind {
+		case "pwnboard":
+			r = pwnboardReporter{urls: urls}
+		case "webhook":
+			r, err = newWebhookReporter(value, webhookTemplate)
+		case "file":
+			r, err = newFileReporter(value)
+		case "stdout":
+			r = stdoutReporter{}
+		default:
+			err = fmt.Errorf("unknown reporter %q, expected pwnboard, webhook=URL, file=PATH or stdout", entry)
+		}
+		if err != nil {
+			reporters.Close()
+			return nil, err
+		}
+		reporters = append(reporters, r)
+	}
+	if len(reporters) == 0 {
+		return nil, errors.New("no reporters given")
+	}
+	return reporters, nil
+}
+
+// multiReporter reports to every one of its reporters.
+type multiReporter []Reporter
+
+func (m multiReporter) Report(t Target, ip string) error {
+	errs := []error{}
+	for _, r := range m {
+		if err := r.Report(t, ip); err != nil {
+			log.Printf("[!] Reporting %s to %s failed: %s\n", t.Name(), r, err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiReporter) String() string {
+	names := make([]string, len(m))
+	for i, r := range m {
+		names[i] = r.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+func (m multiReporter) Close() error {
+	errs := []error{}
+	for _, r := range m {
+		errs = append(errs, r.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// flusher is a Reporter that holds on to reports it could not deliver until
+// they are flushed.
+type flusher interface {
+	// Flush retries the reports held back, or under dryRun lists them.
+	Flush(dryRun bool)
+}
+
+// flushReports flushes r if it holds back reports.
+func flushReports(r Reporter, dryRun bool) {
+	if f, ok := r.(flusher); ok {
+		f.Flush(dryRun)
+	}
+}
+
+func (m multiReporter) Flush(dryRun bool) {
+	for _, r := range m {
+		flushReports(r, dryRun)
+	}
+}
+
+// pwnboardReporter posts to pwnboard through the shared pwnboard client.
+type pwnboardReporter struct {
+	urls string
+}
+
+func (p pwnboardReporter) Report(t Target, ip string) error {
+	return updatepwnBoard(t, ip, p.urls)
+}
+
+func (p pwnboardReporter) String() string {
+	return "pwnboard " + strings.Join(pwnboard.URLs(p.urls), " ")
+}
+
+func (p pwnboardReporter) Close() error { return nil }
+
+// Flush sends the updates spooled when pwnboard could not be reached.
+func (p pwnboardReporter) Flush(dryRun bool) {
+	pwnboard.Flush(dryRun)
+}
+
+// defaultWebhookTemplate posts the accessReport as JSON.
+const defaultWebhookTemplate = "{{json .}}"
+
+// webhookReporter posts a body rendered from a Go template to a URL.
+type webhookReporter struct {
+	url    string
+	body   *template.Template
+	client *http.Client
+}
+
+// newWebhookReporter posts to url with bodies rendered from tmpl, or from the
+// file named by tmpl if it starts with @.
+func newWebhookReporter(url string, tmpl string) (*webhookReporter, error) {
+	if url == "" {
+		return nil, errors.New("webhook reporter needs a URL, as in webhook=https://...")
+	}
+	if strings.HasPrefix(tmpl, "@") {
+		data, err := os.ReadFile(tmpl[1:])
+		if err != nil {
+			return nil, err
+		}
+		tmpl = string(data)
+	}
+	body, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookReporter{url: url, body: body, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (w *webhookReporter) Report(t Target, ip string) error {
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, newAccessReport(t, ip)); err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", &body)
+	if err == nil {
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("status code %d", resp.StatusCode)
+		}
+	}
+	audit.Action(t, auditEntry{Action: "webhook", URL: w.url, IP: ip}, err)
+	return err
+}
+
+func (w *webhookReporter) String() string { return "webhook " + w.url }
+
+func (w *webhookReporter) Close() error { return nil }
+
+// fileReporter appends reports to a CSV or JSONL file.
+type fileReporter struct {
+	path  string
+	jsonl bool
+
+	mu  sync.Mutex
+	f   *os.File
+	csv *csv.Writer
+}
+
+func newFileReporter(path string) (*fileReporter, error) {
+	if path == "" {
+		return nil, errors.New("file reporter needs a path, as in file=hosts.csv")
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := &fileReporter{path: path, jsonl: strings.EqualFold(filepath.Ext(path), ".jsonl"), f: f}
+	if !r.jsonl {
+		r.csv = csv.NewWriter(f)
+		if info, err := f.Stat(); err == nil && info.Size() == 0 {
+			r.csv.Write(csvHeader)
+			r.csv.Flush()
+		}
+	}
+	return r, nil
+}
+
+func (r *fileReporter) Report(t Target, ip string) error {
+	report := newAccessReport(t, ip)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.jsonl {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		_, err = r.f.Write(append(data, '\n'))
+		return err
+	}
+	r.csv.Write(report.csvRecord())
+	r.csv.Flush()
+	return r.csv.Error()
+}
+
+func (r *fileReporter) String() string { return "file " + r.path }
+
+func (r *fileReporter) Close() error {
+	return r.f.Close()
+}
+
+// stdoutReporter prints one line per report.
+type stdoutReporter struct{}
+
+func (stdoutReporter) Report(t Target, ip string) error {
+	fmt.Printf("%s %s %s %s on %s\n", ip, t.Hostname(), t.Kind(), t.Name(), t.Server())
+	return nil
+}
+
+func (stdoutReporter) String() string { return "stdout" }
+
+func (stdoutReporter) Close() error { return nil }
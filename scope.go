@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// scope is the set of hosts Sliverer may act on, loaded from a JSON file:
+//
+//	{"allow": ["10.0.0.0/8", "web*"], "deny": ["10.0.0.1", "dc01"]}
+//
+// Entries are addresses, CIDRs or hostname globs. An implant is refused if
+// its hostname, remote address or any gathered interface address is denied,
+// or if there is an allow list and none of them is allowed. A nil scope
+// permits everything.
+type scope struct {
+	allow scopeRules
+	deny  scopeRules
+
+	mu      sync.Mutex
+	refused map[string]string // implant description -> reason
+}
+
+type scopeRules struct {
+	prefixes []netip.Prefix
+	hosts    []string
+}
+
+func loadScope(file string) (*scope, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Allow []string `json:"allow"`
+		Deny  []string `json:"deny"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	s := &scope{refused: map[string]string{}}
+	if s.allow, err = parseScopeRules(raw.Allow); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if s.deny, err = parseScopeRules(raw.Deny); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s, nil
+}
+
+func parseScopeRules(entries []string) (scopeRules, error) {
+	rules := scopeRules{}
+	for _, entry := range entries {
+		if prefix, err := parsePrefix(entry); err == nil {
+			rules.prefixes = append(rules.prefixes, prefix)
+			continue
+		}
+		host := strings.ToLower(entry)
+		if _, err := path.Match(host, ""); err != nil {
+			return rules, fmt.Errorf("bad scope entry %q: %w", entry, err)
+		}
+		rules.hosts = append(rules.hosts, host)
+	}
+	return rules, nil
+}
+
+func (r scopeRules) empty() bool {
+	return len(r.prefixes) == 0 && len(r.hosts) == 0
+}
+
+// matchHost returns the rule hostname matches, if any.
+func (r scopeRules) matchHost(hostname string) (string, bool) {
+	hostname = strings.ToLower(hostname)
+	for _, host := range r.hosts {
+		if ok, _ := path.Match(host, hostname); ok {
+			return host, true
+		}
+	}
+	return "", false
+}
+
+// matchAddr returns the rule addr falls in, if any.
+func (r scopeRules) matchAddr(addr netip.Addr) (string, bool) {
+	for _, prefix := range r.prefixes {
+		if prefix.Contains(addr) {
+			return prefix.String(), true
+		}
+	}
+	return "", false
+}
+
+// check returns why t, with the given interface addresses, is out of scope,
+// or "" if it is in scope.
+func (s *scope) check(t Target, ips []string) string {
+	addrs := []netip.Addr{}
+	if addr, ok := remoteAddr(t); ok {
+		addrs = append(addrs, addr)
+	}
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+
+	if rule, ok := s.deny.matchHost(t.Hostname()); ok {
+		return "hostname " + t.Hostname() + " is denied by " + rule
+	}
+	for _, addr := range addrs {
+		if rule, ok := s.deny.matchAddr(addr); ok {
+			return "address " + addr.String() + " is denied by " + rule
+		}
+	}
+	if s.allow.empty() {
+		return ""
+	}
+	if _, ok := s.allow.matchHost(t.Hostname()); ok {
+		return ""
+	}
+	for _, addr := range addrs {
+		if _, ok := s.allow.matchAddr(addr); ok {
+			return ""
+		}
+	}
+	return "not in the allow list"
+}
+
+// Allows reports whether t may be acted on without logging a refusal.
+func (s *scope) Allows(t Target, ips ...string) bool {
+	return s == nil || s.check(t, ips) == ""
+}
+
+// Permit reports whether t may be acted on given the interface addresses
+// gathered from it so far. Refusals are logged and remembered for Summary.
+func (s *scope) Permit(t Target, ips ...string) bool {
+	if s == nil {
+		return true
+	}
+	reason := s.check(t, ips)
+	if reason == "" {
+		return true
+	}
+	implant := t.Kind() + " " + t.Name() + "," + t.Hostname()
+	log.Printf("[!] Refusing %s: %s\n", implant, reason)
+	s.mu.Lock()
+	s.refused[implant] = reason
+	s.mu.Unlock()
+	return false
+}
+
+// Summary prints every implant that was refused for being out of scope.
+func (s *scope) Summary() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.refused) == 0 {
+		return
+	}
+	implants := make([]string, 0, len(s.refused))
+	for implant := range s.refused {
+		implants = append(implants, implant)
+	}
+	sort.Strings(implants)
+	log.Printf("[!] Refused %d implants out of scope:\n", len(implants))
+	for _, implant := range implants {
+		log.Printf("[!]   %s: %s\n", implant, s.refused[implant])
+	}
+}
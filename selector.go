@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// selector picks implants by their properties. It is parsed from expressions
+// such as "os=linux and ip=10.5.0.0/16 and checkin<5m and not kind=beacon",
+// where every term has to match. An empty selector matches everything.
+//
+// Terms are field, operator and value without spaces:
+//
+//	hostname, name, os, arch, user, transport, kind
+//	    =glob  !=glob  ~regexp  (case insensitive)
+//	id      =prefix  !=prefix
+//	ip      =address or CIDR  !=address or CIDR  (of the remote address)
+//	checkin <age  >age  (time since the last check-in, e.g. 5m)
+//
+// A term can be negated with "not"; "and" between terms is optional.
+type selector []func(Target) bool
+
+func parseSelector(expr string) (selector, error) {
+	sel := selector{}
+	negate := false
+	for _, word := range strings.Fields(expr) {
+		switch strings.ToLower(word) {
+		case "and":
+			continue
+		case "not":
+			negate = !negate
+			continue
+		}
+		match, err := parseTerm(word)
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			m := match
+			match = func(t Target) bool { return !m(t) }
+			negate = false
+		}
+		sel = append(sel, match)
+	}
+	if negate {
+		return nil, fmt.Errorf("selector %q ends with not", expr)
+	}
+	return sel, nil
+}
+
+func parseTerm(term string) (func(Target) bool, error) {
+	field, op, value := "", "", ""
+	for i := 1; i < len(term) && op == ""; i++ {
+		switch {
+		case strings.HasPrefix(term[i:], "!="):
+			op = "!="
+		case strings.ContainsRune("=~<>", rune(term[i])):
+			op = term[i : i+1]
+		default:
+			continue
+		}
+		field, value = strings.ToLower(term[:i]), term[i+len(op):]
+	}
+	if op == "" || value == "" {
+		return nil, fmt.Errorf("cannot parse selector term %q", term)
+	}
+
+	var match func(Target) bool
+	var err error
+	switch field {
+	case "hostname", "name", "os", "arch", "user", "transport", "kind":
+		match, err = stringTerm(field, op, value)
+	case "id":
+		match, err = idTerm(op, value)
+	case "ip":
+		match, err = ipTerm(op, value)
+	case "checkin":
+		match, err = checkinTerm(op, value)
+	default:
+		return nil, fmt.Errorf("unknown selector field %q", field)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("selector term %q: %w", term, err)
+	}
+	return match, nil
+}
+
+// targetField returns the named string property of t.
+func targetField(t Target, field string) string {
+	switch field {
+	case "hostname":
+		return t.Hostname()
+	case "name":
+		return t.Name()
+	case "os":
+		return t.OS()
+	case "arch":
+		return t.Arch()
+	case "user":
+		return t.Username()
+	case "transport":
+		return t.Transport()
+	case "kind":
+		return t.Kind()
+	}
+	return ""
+}
+
+func stringTerm(field string, op string, value string) (func(Target) bool, error) {
+	switch op {
+	case "=", "!=":
+		pattern := strings.ToLower(value)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		want := op == "="
+		return func(t Target) bool {
+			ok, _ := path.Match(pattern, strings.ToLower(targetField(t, field)))
+			return ok == want
+		}, nil
+	case "~":
+		re, err := regexp.Compile("(?i)" + value)
+		if err != nil {
+			return nil, err
+		}
+		return func(t Target) bool {
+			return re.MatchString(targetField(t, field))
+		}, nil
+	}
+	return nil, fmt.Errorf("operator %s is not supported", op)
+}
+
+func idTerm(op string, value string) (func(Target) bool, error) {
+	if op != "=" && op != "!=" {
+		return nil, fmt.Errorf("operator %s is not supported", op)
+	}
+	prefix := strings.ToLower(value)
+	want := op == "="
+	return func(t Target) bool {
+		return strings.HasPrefix(strings.ToLower(t.ID()), prefix) == want
+	}, nil
+}
+
+func ipTerm(op string, value string) (func(Target) bool, error) {
+	if op != "=" && op != "!=" {
+		return nil, fmt.Errorf("operator %s is not supported", op)
+	}
+	prefix, err := parsePrefix(value)
+	if err != nil {
+		return nil, err
+	}
+	want := op == "="
+	return func(t Target) bool {
+		addr, ok := remoteAddr(t)
+		return ok && prefix.Contains(addr) == want
+	}, nil
+}
+
+func checkinTerm(op string, value string) (func(Target) bool, error) {
+	if op != "<" && op != ">" {
+		return nil, fmt.Errorf("operator %s is not supported", op)
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, err
+	}
+	return func(t Target) bool {
+		since := time.Since(t.LastCheckin())
+		if op == "<" {
+			return since < age
+		}
+		return since > age
+	}, nil
+}
+
+// parsePrefix parses a CIDR, or a single address as a prefix containing only
+// that address.
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// remoteAddr is the address t connects to the server from.
+func remoteAddr(t Target) (netip.Addr, bool) {
+	host := t.RemoteAddress()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// Match reports whether t matches every term of the selector.
+func (s selector) Match(t Target) bool {
+	for _, match := range s {
+		if !match(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter keeps the targets matching the selector.
+func (s selector) Filter(targets []Target) []Target {
+	selected := []Target{}
+	for _, t := range targets {
+		if s.Match(t) {
+			selected = append(selected, t)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os/user"
+	"strings"
+	"sync"
+
+	"github.com/bishopfox/sliver/client/transport"
+	"github.com/bishopfox/sliver/protobuf/rpcpb"
+)
+
+// server is a connected Sliver team server.
+type server struct {
+	name     string // operator@host:port
+	operator string
+	rpc      rpcpb.SliverRPCClient
+	conn     io.Closer
+}
+
+// connectServers connects to every config's server at once. Servers that
+// can't be reached are logged and left out.
+func connectServers(configs []clientConfig) []*server {
+	connected := make([]*server, len(configs))
+	var wg sync.WaitGroup
+	for i, c := range configs {
+		wg.Add(1)
+		go func(i int, c clientConfig) {
+			defer wg.Done()
+			rpc, conn, err := transport.MTLSConnect(c.config)
+			if err != nil {
+				log.Printf("[!] Failed to connect to %s: %s\n", c, err)
+				return
+			}
+			log.Println("[*] Connected to sliver server " + c.String())
+			connected[i] = &server{name: c.name(), operator: c.config.Operator, rpc: rpc, conn: conn}
+		}(i, c)
+	}
+	wg.Wait()
+
+	servers := []*server{}
+	for _, srv := range connected {
+		if srv != nil {
+			servers = append(servers, srv)
+		}
+	}
+	return servers
+}
+
+func closeServers(servers []*server) {
+	for _, srv := range servers {
+		srv.conn.Close()
+	}
+}
+
+// operators lists the distinct operators the servers were connected as.
+func operators(servers []*server) string {
+	names := []string{}
+	for _, srv := range servers {
+		if !isinarray(names, srv.operator) {
+			names = append(names, srv.operator)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// localOperator is who runs Sliverer, for runs that connect to no server.
+func localOperator() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
+// findTargets returns every implant matching opts.selector across all
+// servers. A server that can't be listed is logged and skipped; it is only an
+// error if none of them could be.
+func findTargets(servers []*server, opts runOptions) ([]Target, error) {
+	lists := make([][]Target, len(servers))
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv *server) {
+			defer wg.Done()
+			lists[i], errs[i] = listTargets(srv)
+		}(i, srv)
+	}
+	wg.Wait()
+
+	targets := []Target{}
+	failed := 0
+	for i, srv := range servers {
+		if errs[i] != nil {
+			log.Printf("[!] Failed to list implants on %s: %s\n", srv.name, errs[i])
+			failed++
+			continue
+		}
+		targets = append(targets, opts.selector.Filter(lists[i])...)
+	}
+	if failed > 0 && failed == len(servers) {
+		return nil, errors.New("could not list implants on any server")
+	}
+	return targets, nil
+}
+
+// forEachServer runs fn for every server at once and waits for all of them.
+func forEachServer(ctx context.Context, servers []*server, fn func(context.Context, *server)) {
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *server) {
+			defer wg.Done()
+			fn(ctx, srv)
+		}(srv)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// spoolEntry is a pwnboard update that could not be sent.
+type spoolEntry struct {
+	URL      string    `json:"url"`
+	Payload  PwnBoard  `json:"payload"`
+	Observed time.Time `json:"observed"`
+}
+
+// pwnboardSpool keeps pwnboard updates that failed on disk until a later
+// flush gets them through. Only the latest update per address and URL is
+// kept, since pwnboard only cares when a host was last seen.
+type pwnboardSpool struct {
+	path    string
+	mu      sync.Mutex
+	Entries map[string]*spoolEntry `json:"entries"` // by URL and address
+}
+
+func loadPwnboardSpool(path string) (*pwnboardSpool, error) {
+	spool := &pwnboardSpool{path: path, Entries: map[string]*spoolEntry{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return spool, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, spool); err != nil {
+		return nil, err
+	}
+	if spool.Entries == nil {
+		spool.Entries = map[string]*spoolEntry{}
+	}
+	return spool, nil
+}
+
+func spoolKey(url string, ip string) string {
+	return url + " " + ip
+}
+
+// add spools an update to url observed at when, replacing any older update
+// for the same address and URL.
+func (s *pwnboardSpool) add(url string, payload PwnBoard, when time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := spoolKey(url, payload.IPs)
+	if old, ok := s.Entries[key]; ok && old.Observed.After(when) {
+		return
+	}
+	s.Entries[key] = &spoolEntry{URL: url, Payload: payload, Observed: when}
+	if err := s.save(); err != nil {
+		log.Printf("[!] Failed to save pwnboard spool: %s\n", err)
+	}
+}
+
+// save writes the spool out, or removes it once it is empty. The caller must
+// hold s.mu.
+func (s *pwnboardSpool) save() error {
+	if len(s.Entries) == 0 {
+		err := os.Remove(s.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data)
+}
+
+// entries returns the spooled updates, oldest first.
+func (s *pwnboardSpool) entries() []spoolEntry {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]spoolEntry, 0, len(s.Entries))
+	for _, entry := range s.Entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Observed.Before(entries[j].Observed) })
+	return entries
+}
+
+// Flush sends every spooled update, oldest first, with the time it was
+// observed. Updates that still fail stay spooled, and the rest of a URL's
+// updates are left for later once it cannot be reached at all. Under dryRun
+// the updates are only listed.
+func (c *pwnboardClient) Flush(dryRun bool) {
+	s := c.spool
+	entries := s.entries()
+	if len(entries) == 0 {
+		return
+	}
+	if dryRun {
+		for _, entry := range entries {
+			fmt.Printf("would POST spooled %s, observed %s, to %s\n", entry.Payload.IPs, entry.Observed.Format(time.RFC3339), entry.URL)
+		}
+		fmt.Printf("dry run: %d spooled pwnboard updates would be sent\n", len(entries))
+		return
+	}
+
+	sent := 0
+	unreachable := map[string]bool{}
+	for _, entry := range entries {
+		if unreachable[entry.URL] {
+			continue
+		}
+		payload := entry.Payload
+		payload.Timestamp = entry.Observed.Unix()
+		body, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("[!] Dropping spooled pwnboard update for %s: %s\n", payload.IPs, err)
+			s.remove(entry)
+			continue
+		}
+		status, err := c.Post(entry.URL, body)
+		audit.record(spoolAuditEntry(entry, err))
+		if err != nil {
+			if status == 0 {
+				unreachable[entry.URL] = true
+			}
+			if !transient(status) {
+				log.Printf("[!] Dropping spooled pwnboard update for %s, %s refused it: %s\n", payload.IPs, entry.URL, err)
+				s.remove(entry)
+			}
+			continue
+		}
+		s.remove(entry)
+		sent++
+	}
+	log.Printf("[*] Flushed %d of %d spooled pwnboard updates\n", sent, len(entries))
+}
+
+// remove drops entry from the spool unless it was replaced by a newer update
+// in the meantime.
+func (s *pwnboardSpool) remove(entry spoolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := spoolKey(entry.URL, entry.Payload.IPs)
+	if current, ok := s.Entries[key]; ok && !current.Observed.After(entry.Observed) {
+		delete(s.Entries, key)
+		if err := s.save(); err != nil {
+			log.Printf("[!] Failed to save pwnboard spool: %s\n", err)
+		}
+	}
+}
+
+func spoolAuditEntry(entry spoolEntry, err error) auditEntry {
+	e := auditEntry{Action: "pwnboard", URL: entry.URL, IP: entry.Payload.IPs, Outcome: "ok"}
+	if err != nil {
+		e.Outcome = "error"
+		e.Error = err.Error()
+	}
+	return e
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/bishopfox/sliver/protobuf/clientpb"
+	"github.com/bishopfox/sliver/protobuf/commonpb"
+	"github.com/bishopfox/sliver/protobuf/rpcpb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	kindSession = "session"
+	kindBeacon  = "beacon"
+)
+
+// Target is an implant Sliverer can act on, either an interactive session or
+// a beacon. Workflows are written against Target so they work on both kinds.
+type Target interface {
+	ID() string
+	Name() string
+	Hostname() string
+	OS() string
+	Arch() string
+	Username() string
+	Transport() string
+	RemoteAddress() string
+	LastCheckin() time.Time
+	// NextCheckin is when a beacon is next due to check in, and Interval how
+	// often it checks in. Both are zero for sessions.
+	NextCheckin() time.Time
+	Interval() time.Duration
+	Kind() string
+	IsDead() bool
+	// Server names the team server the implant is connected to.
+	Server() string
+	// Operator is who Sliverer is connected to that server as.
+	Operator() string
+	// RPC is the client of the server the implant is connected to.
+	RPC() rpcpb.SliverRPCClient
+	// Request builds the request header for an RPC against the implant made
+	// under ctx.
+	Request(ctx context.Context) *commonpb.Request
+	// RenameReq builds a request renaming the implant to name.
+	RenameReq(name string) *clientpb.RenameReq
+	// Result fills r with the implant's reply to a request built by Request,
+	// blocking until the reply is available or ctx is done.
+	Result(ctx context.Context, r reply) error
+}
+
+// reply is an implant response such as *sliverpb.Execute or *sliverpb.Ifconfig.
+type reply interface {
+	proto.Message
+	GetResponse() *commonpb.Response
+}
+
+type sessionTarget struct {
+	srv     *server
+	session *clientpb.Session
+}
+
+func newSessionTarget(srv *server, session *clientpb.Session) Target {
+	return &sessionTarget{srv: srv, session: session}
+}
+
+func (s *sessionTarget) ID() string                 { return s.session.ID }
+func (s *sessionTarget) Name() string               { return s.session.Name }
+func (s *sessionTarget) Hostname() string           { return s.session.Hostname }
+func (s *sessionTarget) OS() string                 { return s.session.OS }
+func (s *sessionTarget) Arch() string               { return s.session.Arch }
+func (s *sessionTarget) Username() string           { return s.session.Username }
+func (s *sessionTarget) Transport() string          { return s.session.Transport }
+func (s *sessionTarget) RemoteAddress() string      { return s.session.RemoteAddress }
+func (s *sessionTarget) LastCheckin() time.Time     { return time.Unix(s.session.LastCheckin, 0) }
+func (s *sessionTarget) NextCheckin() time.Time     { return time.Time{} }
+func (s *sessionTarget) Interval() time.Duration    { return 0 }
+func (s *sessionTarget) Kind() string               { return kindSession }
+func (s *sessionTarget) IsDead() bool               { return s.session.IsDead }
+func (s *sessionTarget) Server() string             { return s.srv.name }
+func (s *sessionTarget) Operator() string           { return s.srv.operator }
+func (s *sessionTarget) RPC() rpcpb.SliverRPCClient { return s.srv.rpc }
+
+func (s *sessionTarget) Request(ctx context.Context) *commonpb.Request {
+	return makeRequest(s.session, requestTimeout(ctx))
+}
+
+func (s *sessionTarget) RenameReq(name string) *clientpb.RenameReq {
+	return &clientpb.RenameReq{SessionID: s.session.ID, Name: name}
+}
+
+// Sessions answer synchronously, so the reply is complete as soon as the RPC
+// returns.
+func (s *sessionTarget) Result(ctx context.Context, r reply) error {
+	return replyErr(r)
+}
+
+type beaconTarget struct {
+	srv    *server
+	beacon *clientpb.Beacon
+}
+
+func newBeaconTarget(srv *server, beacon *clientpb.Beacon) Target {
+	return &beaconTarget{srv: srv, beacon: beacon}
+}
+
+func (b *beaconTarget) ID() string                 { return b.beacon.ID }
+func (b *beaconTarget) Name() string               { return b.beacon.Name }
+func (b *beaconTarget) Hostname() string           { return b.beacon.Hostname }
+func (b *beaconTarget) OS() string                 { return b.beacon.OS }
+func (b *beaconTarget) Arch() string               { return b.beacon.Arch }
+func (b *beaconTarget) Username() string           { return b.beacon.Username }
+func (b *beaconTarget) Transport() string          { return b.beacon.Transport }
+func (b *beaconTarget) RemoteAddress() string      { return b.beacon.RemoteAddress }
+func (b *beaconTarget) LastCheckin() time.Time     { return time.Unix(b.beacon.LastCheckin, 0) }
+func (b *beaconTarget) NextCheckin() time.Time     { return time.Unix(b.beacon.NextCheckin, 0) }
+func (b *beaconTarget) Interval() time.Duration    { return time.Duration(b.beacon.Interval) }
+func (b *beaconTarget) Kind() string               { return kindBeacon }
+func (b *beaconTarget) IsDead() bool               { return b.beacon.IsDead }
+func (b *beaconTarget) Server() string             { return b.srv.name }
+func (b *beaconTarget) Operator() string           { return b.srv.operator }
+func (b *beaconTarget) RPC() rpcpb.SliverRPCClient { return b.srv.rpc }
+
+func (b *beaconTarget) Request(ctx context.Context) *commonpb.Request {
+	return makeBeaconRequest(b.beacon, requestTimeout(ctx))
+}
+
+func (b *beaconTarget) RenameReq(name string) *clientpb.RenameReq {
+	return &clientpb.RenameReq{BeaconID: b.beacon.ID, Name: name}
+}
+
+// Beacon RPCs only return a task ID. Result waits for the beacon to check in
+// with the task and decodes the task's response into r.
+func (b *beaconTarget) Result(ctx context.Context, r reply) error {
+	resp := r.GetResponse()
+	if resp == nil || !resp.Async {
+		return replyErr(r)
+	}
+	task, err := awaiterFor(b.srv.rpc).Await(ctx, resp.TaskID)
+	if err != nil {
+		return err
+	}
+	if task.State == "canceled" {
+		return errors.New("task " + resp.TaskID + " was canceled")
+	}
+	if err := proto.Unmarshal(task.Response, r); err != nil {
+		return err
+	}
+	return replyErr(r)
+}
+
+func replyErr(r reply) error {
+	if resp := r.GetResponse(); resp != nil && resp.Err != "" {
+		return errors.New(resp.Err)
+	}
+	return nil
+}
+
+// listTargets returns every session and beacon known to the server.
+func listTargets(srv *server) ([]Target, error) {
+	sessions, err := srv.rpc.GetSessions(context.Background(), &commonpb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	beacons, err := srv.rpc.GetBeacons(context.Background(), &commonpb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	targets := []Target{}
+	for _, session := range sessions.Sessions {
+		targets = append(targets, newSessionTarget(srv, session))
+	}
+	for _, beacon := range beacons.Beacons {
+		targets = append(targets, newBeaconTarget(srv, beacon))
+	}
+	return targets, nil
+}
+
+// selectTargets keeps the targets of the given kind whose name is in names.
+func selectTargets(targets []Target, kind string, names []string) []Target {
+	selected := []Target{}
+	for _, t := range targets {
+		if t.Kind() == kind && isinarray(names, t.Name()) {
+			selected = append(selected, t)
+		}
+	}
+	return selected
+}
+
+// runOptions control which targets a run acts on, how runOn spreads work
+// over them and how the results are presented.
+type runOptions struct {
+	selector    selector         // which implants to act on
+	scope       *scope           // which hosts may be acted on at all
+	parallel    int              // how many targets are tasked at once
+	timeout     time.Duration    // how long a session may take to answer
+	wait        time.Duration    // how long beacons may take to check in
+	output      string           // format command results are printed in
+	dryRun      bool             // print the plan instead of tasking anything
+	late        int              // beacon intervals since the last check-in before a beacon is lost
+	names       nameTemplate     // what rename calls implants
+	filter      addrFilter       // which interface addresses are worth using
+	addrs       *addrPolicy      // which address a host is renamed after and reported as
+	yes         bool             // skip confirmation of large or dangerous commands
+	confirmOver int              // commands tasking more implants need confirmation
+	dangerous   []*regexp.Regexp // commands that always need confirmation
+}
+
+// outcome is what came of tasking one target.
+type outcome struct {
+	target   Target
+	reply    reply // nil if the request could not be issued
+	err      error
+	taskID   string // set for beacons
+	started  time.Time
+	finished time.Time
+}
+
+// runOn issues an RPC against every live, in scope target and hands each reply to done
+// as soon as it is available. At most opts.parallel RPCs are in flight at
+// once. Session replies arrive with the call and are abandoned after
+// opts.timeout; beacon replies arrive when the beacon checks in, or not at all
+// if ctx expires first. Calls to done are serialized, so output written by
+// done stays grouped per target.
+func runOn(ctx context.Context, opts runOptions, targets []Target, issue func(context.Context, Target) (reply, error), done func(outcome)) {
+	var mu sync.Mutex
+	report := func(o outcome) {
+		o.finished = time.Now()
+		if o.reply != nil {
+			audit.Outcome(o)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		done(o)
+	}
+
+	parallel := opts.parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		if t.IsDead() {
+			println(t.Hostname() + " is dead")
+			continue
+		}
+		if !opts.scope.Permit(t) {
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(t Target) {
+			defer wg.Done()
+			tctx, cancel := ctx, context.CancelFunc(func() {})
+			if t.Kind() == kindSession && opts.timeout > 0 {
+				tctx, cancel = context.WithTimeout(ctx, opts.timeout)
+			}
+			defer cancel()
+
+			o := outcome{target: t, started: time.Now()}
+			o.reply, o.err = issue(tctx, t)
+			// Beacons don't hold a slot while we wait for them to check in.
+			<-slots
+			if o.err == nil {
+				if resp := o.reply.GetResponse(); resp != nil {
+					o.taskID = resp.TaskID
+				}
+				o.err = t.Result(tctx, o.reply)
+			}
+			if o.err != nil && tctx.Err() == context.DeadlineExceeded {
+				o.err = errors.New("didnt hear from " + t.Name() + "," + t.Hostname())
+			}
+			report(o)
+		}(t)
+	}
+	wg.Wait()
+}